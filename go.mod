@@ -14,10 +14,14 @@ require (
 	github.com/labstack/gommon v0.3.0 // indirect
 	github.com/mavricknz/asn1-ber v0.0.0-20151103223136-b9df1c2f4213 // indirect
 	github.com/mavricknz/ldap v0.0.0-20160227184754-f5a958005e43 // indirect
+	github.com/prometheus/client_golang v1.7.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sevenNt/echo-pprof v0.1.0 // indirect
 	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/valyala/fasttemplate v1.2.1 // indirect
-	go.uber.org/zap v1.16.0 // indirect
+	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a // indirect
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
 	gopkg.in/cas.v2 v2.2.0 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/labstack/echo"
+)
+
+//ValidateConfig reports the problems with the replication config document in the request body
+func ValidateConfig(context echo.Context) error {
+	var config replication.ReplicationConfig
+	if err := context.Bind(&config); err != nil {
+		return context.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	return context.JSON(http.StatusOK, replication.ValidateConfig(context.Request().Context(), config))
+}
+
+//ResolveConfig shows the config that would be used for the hostname in the query, and the rules it was built from
+func ResolveConfig(context echo.Context) error {
+	hostname := context.QueryParam("hostname")
+	if len(hostname) == 0 {
+		return context.JSON(http.StatusBadRequest, "hostname is required")
+	}
+
+	resolved, err := replication.ResolveConfig(context.Request().Context(), hostname)
+	if err != nil {
+		if err.Type == "not_found" {
+			return context.JSON(http.StatusNotFound, err.Error())
+		}
+		return context.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return context.JSON(http.StatusOK, resolved)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/labstack/echo"
+)
+
+//Healthz is the liveness check. It fails if the scheduler has stopped or its jobs are stuck, but not while the
+//service is still starting up.
+func Healthz(context echo.Context) error {
+	return healthResponse(context, replication.Liveness())
+}
+
+//Readyz is the readiness check. It fails until the local and remote couch servers can be reached, the config has been
+//replicated, and every database has been replicated at least once.
+func Readyz(context echo.Context) error {
+	return healthResponse(context, replication.Readiness(context.Request().Context()))
+}
+
+func healthResponse(context echo.Context, h replication.Health) error {
+	if !h.OK {
+		return context.JSON(http.StatusServiceUnavailable, h)
+	}
+
+	return context.JSON(http.StatusOK, h)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/labstack/echo"
+)
+
+//completedCouch answers as a couch server where every replication has already completed
+func completedCouch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+		now := time.Now().UTC().Format(time.RFC3339)
+
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"ok":true}`)) // nolint:errcheck
+		case path == "":
+			w.Write([]byte(`{"couchdb":"Welcome"}`)) // nolint:errcheck
+		case path == "_active_tasks":
+			w.Write([]byte(`[]`)) // nolint:errcheck
+		case path == "_scheduler/jobs":
+			w.Write([]byte(`{"jobs":[]}`)) // nolint:errcheck
+		case path == "_scheduler/docs/_replicator":
+			w.Write([]byte(`{"docs":[]}`)) // nolint:errcheck
+		case strings.HasPrefix(path, "_scheduler/docs/_replicator/"):
+			id := strings.TrimPrefix(path, "_scheduler/docs/_replicator/")
+			w.Write([]byte(`{"doc_id":"` + id + `","state":"completed","last_updated":"` + now + `"}`)) // nolint:errcheck
+		case strings.HasPrefix(path, "_replicator/"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not_found","reason":"missing"}`)) // nolint:errcheck
+		case !strings.Contains(path, "/"):
+			w.Write([]byte(`{"db_name":"` + path + `","doc_count":0,"update_seq":"0-abc"}`)) // nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not_found","reason":"missing"}`)) // nolint:errcheck
+		}
+	})
+}
+
+func readyz(t *testing.T) (int, replication.Health) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	context := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)
+	if err := Readyz(context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var h replication.Health
+	if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return rec.Code, h
+}
+
+func TestReadyzAfterStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := replication.Init(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if code, h := readyz(t); code != http.StatusServiceUnavailable {
+		t.Errorf("got %v %+v before the jobs started, want 503", code, h)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		replication.Start(ctx) // nolint:errcheck
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		code, h := readyz(t)
+		if code == http.StatusOK {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v %+v after the jobs started, want 200", code, h)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
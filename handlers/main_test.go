@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//couch is the local and remote couch server for every test. The replication package is only configured once, since
+//it can't be configured again while the watchers started by Init are running, so tests change how it answers instead.
+var couch = struct {
+	sync.Mutex
+	handler http.Handler
+}{handler: completedCouch()}
+
+//useCouch makes h answer couch requests for the rest of the test
+func useCouch(t *testing.T, h http.Handler) {
+	t.Helper()
+
+	couch.Lock()
+	couch.handler = h
+	couch.Unlock()
+
+	t.Cleanup(func() {
+		couch.Lock()
+		couch.handler = completedCouch()
+		couch.Unlock()
+	})
+}
+
+func TestMain(m *testing.M) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		couch.Lock()
+		h := couch.handler
+		couch.Unlock()
+
+		h.ServeHTTP(w, r)
+	}))
+
+	s := settings.Default()
+	s.Hostname = "ITB-1101-CP1"
+	s.CouchAddr = srv.URL
+	s.RemoteAddr = srv.URL
+	s.RemoteUser = "user"
+	s.RemotePass = "pass"
+	s.ConfigSource = replication.ConfigSourceEnv
+	s.ConfigDocument = `{"rules": [{"hostname": ".*", "replications": [
+		{"database": "devices", "interval": 60},
+		{"database": "rooms", "continuous": true}
+	]}]}`
+	s.HistoryLimit = 0
+
+	if err := replication.Configure(s); err != nil {
+		srv.Close()
+		panic(err.Error())
+	}
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
@@ -1,14 +1,23 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/byuoitav/common/nerr"
 	"github.com/byuoitav/couch-db-repl/replication"
 	"github.com/labstack/echo"
 )
 
+const (
+	defaultWaitTimeout = 60 * time.Second
+	maxWaitTimeout     = 10 * time.Minute
+)
+
 func ReplicateNow(context echo.Context) error {
-	err := replication.ReplicateNow()
+	err := replication.ReplicateNow(context.Request().Context())
 	if err != nil {
 		return context.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -16,3 +25,167 @@ func ReplicateNow(context echo.Context) error {
 	return context.JSON(http.StatusOK, "replication scheduled")
 
 }
+
+//ReplicateDatabaseNow schedules a replication of a single database, and reports what happened to each of its
+//replication documents. With ?wait=true it waits (up to ?timeout seconds) for the replication to complete or fail, or
+//for a continuous replication to catch up.
+func ReplicateDatabaseNow(context echo.Context) error {
+	db := context.Param("db")
+
+	wait, _ := strconv.ParseBool(context.QueryParam("wait"))
+	timeout := defaultWaitTimeout
+	if t := context.QueryParam("timeout"); wait && len(t) > 0 {
+		secs, err := strconv.Atoi(t)
+		if err != nil || secs <= 0 {
+			return context.JSON(http.StatusBadRequest, "timeout must be a positive number of seconds")
+		}
+
+		timeout = time.Duration(secs) * time.Second
+		if timeout > maxWaitTimeout {
+			timeout = maxWaitTimeout
+		}
+	}
+
+	legs, err := replication.ReplicateDatabaseNow(context.Request().Context(), db)
+	resp := map[string]interface{}{
+		"database":     db,
+		"replications": legs,
+	}
+
+	//if every leg is already running there's nothing new to wait on, but the running ones can still be waited on
+	if err != nil && (!wait || err.Type != "duplicate_repl") {
+		resp["error"] = errorResponse(err)
+		return context.JSON(errorStatus(err), resp)
+	}
+
+	if !wait {
+		return context.JSON(http.StatusOK, resp)
+	}
+
+	states, err := waitForReplication(context.Request(), db, timeout)
+	resp["states"] = states
+
+	if err != nil {
+		resp["error"] = errorResponse(err)
+		return context.JSON(errorStatus(err), resp)
+	}
+
+	return context.JSON(http.StatusOK, resp)
+}
+
+//waitForReplication waits up to timeout for the replication of db to finish, or for req to be cancelled
+func waitForReplication(req *http.Request, db string, timeout time.Duration) (map[string]string, *nerr.E) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	return replication.WaitForReplication(ctx, db)
+}
+
+func ReplicationStatus(context echo.Context) error {
+	return context.JSON(http.StatusOK, replication.GetStatus(context.Request().Context()))
+}
+
+//ReplicationSources returns the health of each remote source, and which one is being replicated from
+func ReplicationSources(context echo.Context) error {
+	return context.JSON(http.StatusOK, replication.GetSources())
+}
+
+func DatabaseReplicationStatus(context echo.Context) error {
+	status, err := replication.GetDatabaseStatus(context.Request().Context(), context.Param("db"))
+	if err != nil {
+		if err.Type == "not_found" {
+			return context.JSON(http.StatusNotFound, err.Error())
+		}
+		return context.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return context.JSON(http.StatusOK, status)
+}
+
+//errorStatus maps the type of a replication error to a response code
+func errorStatus(err *nerr.E) int {
+	switch err.Type {
+	case "not_found":
+		return http.StatusNotFound
+	case "duplicate_repl":
+		return http.StatusConflict
+	case "invalid_args":
+		return http.StatusBadRequest
+	case "stopped":
+		return http.StatusServiceUnavailable
+	case "timeout":
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func errorResponse(err *nerr.E) map[string]string {
+	return map[string]string{
+		"type":  err.Type,
+		"error": err.Error(),
+	}
+}
+
+//ReplicationProgress returns how far along the replications of every database are, and how far behind their sources
+//they are
+func ReplicationProgress(context echo.Context) error {
+	progress, err := replication.GetProgress(context.Request().Context())
+	if err != nil {
+		return context.JSON(errorStatus(err), errorResponse(err))
+	}
+
+	return context.JSON(http.StatusOK, progress)
+}
+
+//DatabaseReplicationProgress returns how far along the replications of a single database are
+func DatabaseReplicationProgress(context echo.Context) error {
+	progress, err := replication.GetDatabaseProgress(context.Request().Context(), context.Param("db"))
+	if err != nil {
+		return context.JSON(errorStatus(err), errorResponse(err))
+	}
+
+	return context.JSON(http.StatusOK, progress)
+}
+
+//VerifyReplications checks every database being replicated against its source
+func VerifyReplications(context echo.Context) error {
+	return context.JSON(http.StatusOK, replication.VerifyAll(context.Request().Context()))
+}
+
+//VerifyDatabaseReplication checks a single database being replicated against its source
+func VerifyDatabaseReplication(context echo.Context) error {
+	v, err := replication.VerifyDatabase(context.Request().Context(), context.Param("db"))
+	if err != nil {
+		return context.JSON(errorStatus(err), errorResponse(err))
+	}
+
+	return context.JSON(http.StatusOK, v)
+}
+
+//ReplicationEvents streams replication events over a websocket as they happen
+func ReplicationEvents(context echo.Context) error {
+	replication.EventStream().ServeHTTP(context.Response(), context.Request())
+	return nil
+}
+
+//ReplicationHistory returns the newest runs of the replications of a database, newest first. ?limit limits how many
+//are returned.
+func ReplicationHistory(context echo.Context) error {
+	limit := 0
+	if l := context.QueryParam("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return context.JSON(http.StatusBadRequest, "limit must be a positive number")
+		}
+
+		limit = n
+	}
+
+	history, err := replication.GetHistory(context.Request().Context(), context.Param("db"), limit)
+	if err != nil {
+		return context.JSON(errorStatus(err), errorResponse(err))
+	}
+
+	return context.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+//steppingCouch is a couch server whose replication documents don't exist until they're posted, unless they're
+//already running, and then go through states, one per check, staying in the last one
+func steppingCouch(states []string, tasks string, running bool) http.Handler {
+	var mu sync.Mutex
+	posted := running
+	checks := 0
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+
+		switch {
+		case r.Method == http.MethodPost:
+			mu.Lock()
+			posted = true
+			mu.Unlock()
+
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"ok":true}`)) // nolint:errcheck
+		case path == "_active_tasks":
+			w.Write([]byte(tasks)) // nolint:errcheck
+		case strings.HasPrefix(path, "_scheduler/docs/_replicator/"):
+			mu.Lock()
+			state := ""
+			if posted {
+				state = states[checks]
+				if checks < len(states)-1 {
+					checks++
+				}
+			}
+			mu.Unlock()
+
+			if len(state) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not_found","reason":"missing"}`)) // nolint:errcheck
+				return
+			}
+
+			id := strings.TrimPrefix(path, "_scheduler/docs/_replicator/")
+			w.Write([]byte(`{"doc_id":"` + id + `","state":"` + state + `"}`)) // nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not_found","reason":"missing"}`)) // nolint:errcheck
+		}
+	})
+}
+
+func TestReplicateDatabaseNowWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      string
+		running bool
+		states  []string
+		tasks   string
+		code    int
+		want    string
+	}{
+		{
+			name:   "completed",
+			db:     "devices",
+			states: []string{"pending", "initializing", "completed"},
+			tasks:  `[]`,
+			code:   http.StatusOK,
+			want:   "completed",
+		},
+		{
+			name:    "already running",
+			db:      "devices",
+			running: true,
+			states:  []string{"running", "running", "completed"},
+			tasks:   `[]`,
+			code:    http.StatusOK,
+			want:    "completed",
+		},
+		{
+			name:   "crashing",
+			db:     "devices",
+			states: []string{"pending", "crashing"},
+			tasks:  `[]`,
+			code:   http.StatusInternalServerError,
+			want:   "crashing",
+		},
+		{
+			name:   "caught up",
+			db:     "rooms",
+			states: []string{"running"},
+			tasks:  `[{"type":"replication","doc_id":"auto_rooms","continuous":true,"changes_pending":0}]`,
+			code:   http.StatusOK,
+			want:   "running",
+		},
+		{
+			name:   "behind",
+			db:     "rooms",
+			states: []string{"running"},
+			tasks:  `[{"type":"replication","doc_id":"auto_rooms","continuous":true,"changes_pending":12}]`,
+			code:   http.StatusGatewayTimeout,
+			want:   "running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCouch(t, steppingCouch(tt.states, tt.tasks, tt.running))
+
+			rec := httptest.NewRecorder()
+			context := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/replicate/"+tt.db+"?wait=true&timeout=3", nil), rec)
+			context.SetParamNames("db")
+			context.SetParamValues(tt.db)
+
+			if err := ReplicateDatabaseNow(context); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var resp struct {
+				States map[string]string `json:"states"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if rec.Code != tt.code || resp.States["auto_"+tt.db] != tt.want {
+				t.Errorf("got %v %s, want %v with the replication %v", rec.Code, rec.Body.Bytes(), tt.code, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicateDatabaseNowAlreadyRunning(t *testing.T) {
+	useCouch(t, steppingCouch([]string{"running"}, `[]`, true))
+
+	rec := httptest.NewRecorder()
+	context := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/replicate/devices", nil), rec)
+	context.SetParamNames("db")
+	context.SetParamValues("devices")
+
+	if err := ReplicateDatabaseNow(context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %v %s, want a conflict when not waiting on a running replication", rec.Code, rec.Body.Bytes())
+	}
+}
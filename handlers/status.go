@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/labstack/echo"
+)
+
+//Status is the mstatus handler, reporting the service as sick while replication is degraded
+func Status(context echo.Context) error {
+	s := status.NewBaseStatus()
+	s.Name = "couch-db-repl"
+
+	state := replication.GetServiceState()
+	if state.State == replication.StateDegraded {
+		s.StatusCode = status.Sick
+	}
+
+	s.Info["replication"] = state
+	return context.JSON(http.StatusOK, s)
+}
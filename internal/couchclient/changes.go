@@ -0,0 +1,49 @@
+package couchclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Change is a single change from a database's _changes feed
+type Change struct {
+	Seq     json.RawMessage `json:"seq"`
+	ID      string          `json:"id"`
+	Deleted bool            `json:"deleted"`
+}
+
+//ChangesResponse is a batch of changes from a database's _changes feed
+type ChangesResponse struct {
+	Results []Change        `json:"results"`
+	LastSeq json.RawMessage `json:"last_seq"`
+}
+
+//Since returns the sequence to pass to the next request to pick up where this one left off
+func (r ChangesResponse) Since() string {
+	var s string
+	if err := json.Unmarshal(r.LastSeq, &s); err == nil {
+		return s
+	}
+
+	//older versions of couch use numbers
+	return strings.TrimSpace(string(r.LastSeq))
+}
+
+//LongPollChanges waits for changes to db after since (which may be "now"), returning once there are some or after
+//timeout. The timeout should be less than the client's request timeout.
+func (c *Client) LongPollChanges(ctx context.Context, db, since string, timeout time.Duration) (ChangesResponse, error) {
+	var resp ChangesResponse
+
+	query := url.Values{}
+	query.Set("feed", "longpoll")
+	query.Set("since", since)
+	query.Set("timeout", fmt.Sprintf("%d", timeout.Milliseconds()))
+
+	err := c.do(ctx, http.MethodGet, escape(db)+"/_changes?"+query.Encode(), nil, &resp)
+	return resp, err
+}
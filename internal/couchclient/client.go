@@ -0,0 +1,176 @@
+//Package couchclient is a small client for the parts of the CouchDB api used to manage replications.
+package couchclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultTimeout is the timeout used for requests if one isn't given with WithTimeout
+const DefaultTimeout = 30 * time.Second
+
+//Client makes requests against a single couch server
+type Client struct {
+	addr string
+
+	credsMu sync.RWMutex
+	user    string
+	pass    string
+
+	http *http.Client
+}
+
+//Option configures a Client
+type Option func(*Client)
+
+//WithTimeout sets the timeout of each request made by the client
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.http.Timeout = timeout
+	}
+}
+
+//WithHTTPClient sets the http client used to make requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+//New returns a client for the couch server at addr, authenticating with user and pass if they are set
+func New(addr, user, pass string, opts ...Option) *Client {
+	c := &Client{
+		addr: strings.TrimRight(addr, "/"),
+		user: user,
+		pass: pass,
+		http: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+//Addr is the address of the couch server
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+//SetCredentials changes the username and password used for requests
+func (c *Client) SetCredentials(user, pass string) {
+	c.credsMu.Lock()
+	defer c.credsMu.Unlock()
+
+	c.user = user
+	c.pass = pass
+}
+
+//Credentials returns the username and password used for requests
+func (c *Client) Credentials() (string, string) {
+	c.credsMu.RLock()
+	defer c.credsMu.RUnlock()
+
+	return c.user, c.pass
+}
+
+//Ping checks that the couch server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "", nil, nil)
+}
+
+//ServerInfo is what couch returns about itself
+type ServerInfo struct {
+	Version string `json:"version"`
+}
+
+//AtLeast reports whether the server's version is major.minor or later
+func (i ServerInfo) AtLeast(major, minor int) bool {
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(i.Version, "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return false
+	}
+
+	if gotMajor != major {
+		return gotMajor > major
+	}
+
+	return gotMinor >= minor
+}
+
+//Info gets information about the couch server
+func (c *Client) Info(ctx context.Context) (ServerInfo, error) {
+	var info ServerInfo
+	err := c.do(ctx, http.MethodGet, "", nil, &info)
+	return info, err
+}
+
+//do makes a request to path on the couch server. If body isn't nil it's sent as json, and if out isn't nil the
+//response is decoded into it.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to marshal request body: %w", err)
+		}
+
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/"+strings.TrimLeft(path, "/"), reader)
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if user, pass := c.Credentials(); len(user) > 0 || len(pass) > 0 {
+		req.SetBasicAuth(user, pass)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response: %w", err)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return newError(resp.StatusCode, b)
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("unable to decode response: %w", err)
+	}
+
+	return nil
+}
+
+//escape escapes a single path segment (a database name or document id)
+func escape(s string) string {
+	return url.PathEscape(s)
+}
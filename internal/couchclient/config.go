@@ -0,0 +1,11 @@
+package couchclient
+
+import (
+	"context"
+	"net/http"
+)
+
+//SetNodeConfig sets a value in the config of the node the client is connected to
+func (c *Client) SetNodeConfig(ctx context.Context, section, key, value string) error {
+	return c.do(ctx, http.MethodPut, "_node/_local/_config/"+escape(section)+"/"+escape(key), value, nil)
+}
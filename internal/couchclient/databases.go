@@ -0,0 +1,26 @@
+package couchclient
+
+import (
+	"context"
+	"net/http"
+)
+
+//DatabaseInfo is the information couch returns about a database
+type DatabaseInfo struct {
+	Name      string      `json:"db_name"`
+	DocCount  int         `json:"doc_count"`
+	DelCount  int         `json:"doc_del_count"`
+	UpdateSeq interface{} `json:"update_seq"`
+}
+
+//GetDatabase gets information about a database
+func (c *Client) GetDatabase(ctx context.Context, db string) (DatabaseInfo, error) {
+	var info DatabaseInfo
+	err := c.do(ctx, http.MethodGet, escape(db), nil, &info)
+	return info, err
+}
+
+//CreateDatabase creates a database
+func (c *Client) CreateDatabase(ctx context.Context, db string) error {
+	return c.do(ctx, http.MethodPut, escape(db), nil, nil)
+}
@@ -0,0 +1,122 @@
+package couchclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//DocumentResponse is couch's response to writing a document
+type DocumentResponse struct {
+	OK  bool   `json:"ok"`
+	ID  string `json:"id"`
+	Rev string `json:"rev"`
+}
+
+type revision struct {
+	Rev string `json:"_rev"`
+}
+
+//GetDocument gets the document id from db and decodes it into doc
+func (c *Client) GetDocument(ctx context.Context, db, id string, doc interface{}) error {
+	return c.do(ctx, http.MethodGet, fmt.Sprintf("%v/%v", escape(db), escape(id)), nil, doc)
+}
+
+//PutDocument creates or updates the document id in db
+func (c *Client) PutDocument(ctx context.Context, db, id string, doc interface{}) (DocumentResponse, error) {
+	var resp DocumentResponse
+	err := c.do(ctx, http.MethodPut, fmt.Sprintf("%v/%v", escape(db), escape(id)), doc, &resp)
+	return resp, err
+}
+
+//PostDocument creates a document in db, using the _id in the document if it has one
+func (c *Client) PostDocument(ctx context.Context, db string, doc interface{}) (DocumentResponse, error) {
+	var resp DocumentResponse
+	err := c.do(ctx, http.MethodPost, escape(db), doc, &resp)
+	return resp, err
+}
+
+//DeleteDocument deletes the document id from db. If rev is empty, the current revision is looked up first.
+func (c *Client) DeleteDocument(ctx context.Context, db, id, rev string) error {
+	if len(rev) == 0 {
+		var cur revision
+		if err := c.GetDocument(ctx, db, id, &cur); err != nil {
+			return err
+		}
+
+		rev = cur.Rev
+	}
+
+	path := fmt.Sprintf("%v/%v?rev=%v", escape(db), escape(id), url.QueryEscape(rev))
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+//AllDocsOptions limit which documents AllDocs returns. Keys are compared in the order the documents are returned, so
+//when Descending is set StartKey is the highest key.
+type AllDocsOptions struct {
+	StartKey   string
+	EndKey     string
+	Descending bool
+	Limit      int
+	Skip       int
+}
+
+//AllDocs gets the documents in db with ids in the range given by opts, decoding them into docs, which must be a
+//pointer to a slice
+func (c *Client) AllDocs(ctx context.Context, db string, opts AllDocsOptions, docs interface{}) error {
+	query := url.Values{}
+	query.Set("include_docs", "true")
+
+	if len(opts.StartKey) > 0 {
+		b, _ := json.Marshal(opts.StartKey)
+		query.Set("startkey", string(b))
+	}
+
+	if len(opts.EndKey) > 0 {
+		b, _ := json.Marshal(opts.EndKey)
+		query.Set("endkey", string(b))
+	}
+
+	if opts.Descending {
+		query.Set("descending", "true")
+	}
+
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	if opts.Skip > 0 {
+		query.Set("skip", strconv.Itoa(opts.Skip))
+	}
+
+	var resp struct {
+		Rows []struct {
+			Doc json.RawMessage `json:"doc"`
+		} `json:"rows"`
+	}
+
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%v/_all_docs?%v", escape(db), query.Encode()), nil, &resp); err != nil {
+		return err
+	}
+
+	raw := make([]json.RawMessage, 0, len(resp.Rows))
+	for _, row := range resp.Rows {
+		if len(row.Doc) > 0 && string(row.Doc) != "null" {
+			raw = append(raw, row.Doc)
+		}
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("unable to marshal documents: %w", err)
+	}
+
+	if err := json.Unmarshal(b, docs); err != nil {
+		return fmt.Errorf("unable to decode documents: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package couchclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+)
+
+//Endpoint is the source or target of a replication. Credentials are given in Auth or Headers instead of the url,
+//so they aren't stored in the url of the replication document.
+type Endpoint struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Auth    *EndpointAuth     `json:"auth,omitempty"`
+}
+
+//EndpointAuth is the auth object of an endpoint, supported by couch 3.2 and later
+type EndpointAuth struct {
+	Basic *BasicAuth `json:"basic,omitempty"`
+}
+
+//BasicAuth is a username and password
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+//UnmarshalJSON accepts both the object form of an endpoint and a plain url
+func (e *Endpoint) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*e = Endpoint{URL: s}
+		return nil
+	}
+
+	type endpoint Endpoint
+	return json.Unmarshal(b, (*endpoint)(e))
+}
+
+//HasEmbeddedCredentials reports whether the endpoint's url has a username or password in it
+func (e Endpoint) HasEmbeddedCredentials() bool {
+	u, err := url.Parse(e.URL)
+	return err == nil && u.User != nil
+}
+
+//BasicAuthHeader is the value of an Authorization header for user and pass
+func BasicAuthHeader(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
@@ -0,0 +1,56 @@
+package couchclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+//Error is an error response from the couch server
+type Error struct {
+	StatusCode int
+	Name       string `json:"error"`
+	Reason     string `json:"reason"`
+}
+
+func newError(status int, body []byte) *Error {
+	e := &Error{StatusCode: status}
+
+	//the body is usually a couch error, but we still want an error back if it isn't
+	_ = json.Unmarshal(body, e)
+
+	if len(e.Name) == 0 {
+		e.Name = http.StatusText(status)
+	}
+
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("couch returned %v (%v): %v", e.StatusCode, e.Name, e.Reason)
+}
+
+//IsNotFound reports whether err is a couch not found error
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+//IsConflict reports whether err is a couch document conflict
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+//IsUnauthorized reports whether err is caused by missing or bad credentials
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+func hasStatus(err error, status int) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode == status
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package couchclient
+
+import (
+	"context"
+	"net/http"
+)
+
+//FindRequest is a mango query
+type FindRequest struct {
+	Selector interface{} `json:"selector"`
+	Fields   []string    `json:"fields,omitempty"`
+	Limit    int         `json:"limit,omitempty"`
+	Skip     int         `json:"skip,omitempty"`
+}
+
+//DocumentRev is the id and current revision of a document
+type DocumentRev struct {
+	ID      string `json:"_id"`
+	Rev     string `json:"_rev"`
+	Deleted bool   `json:"_deleted,omitempty"`
+}
+
+//Find runs a mango query against db, returning the id and revision of each matching document
+func (c *Client) Find(ctx context.Context, db string, req FindRequest) ([]DocumentRev, error) {
+	req.Fields = []string{"_id", "_rev"}
+
+	var resp struct {
+		Docs []DocumentRev `json:"docs"`
+	}
+
+	err := c.do(ctx, http.MethodPost, escape(db)+"/_find", req, &resp)
+	return resp.Docs, err
+}
+
+type allDocsRow struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Error string `json:"error"`
+	Value *struct {
+		Rev     string `json:"rev"`
+		Deleted bool   `json:"deleted"`
+	} `json:"value"`
+}
+
+//DocumentRevs gets the current revision of each of ids in db, keyed by id. Documents that have never existed in db
+//are left out, and deleted ones are included with Deleted set.
+func (c *Client) DocumentRevs(ctx context.Context, db string, ids []string) (map[string]DocumentRev, error) {
+	var resp struct {
+		Rows []allDocsRow `json:"rows"`
+	}
+
+	body := map[string][]string{"keys": ids}
+	if err := c.do(ctx, http.MethodPost, escape(db)+"/_all_docs", body, &resp); err != nil {
+		return nil, err
+	}
+
+	toReturn := make(map[string]DocumentRev, len(resp.Rows))
+	for _, row := range resp.Rows {
+		if len(row.Error) > 0 || row.Value == nil {
+			continue
+		}
+
+		toReturn[row.Key] = DocumentRev{
+			ID:      row.Key,
+			Rev:     row.Value.Rev,
+			Deleted: row.Value.Deleted,
+		}
+	}
+
+	return toReturn, nil
+}
@@ -0,0 +1,39 @@
+package couchclient
+
+import (
+	"context"
+)
+
+//ReplicatorDB is the database replication documents are stored in
+const ReplicatorDB = "_replicator"
+
+//ReplicationDoc is a document in the _replicator database
+type ReplicationDoc struct {
+	ID           string            `json:"_id"`
+	Rev          string            `json:"_rev,omitempty"`
+	Source       Endpoint          `json:"source"`
+	Target       Endpoint          `json:"target"`
+	CreateTarget bool              `json:"create_target"`
+	Continuous   bool              `json:"continuous"`
+	Selector     interface{}       `json:"selector,omitempty"`
+	Filter       string            `json:"filter,omitempty"`
+	QueryParams  map[string]string `json:"query_params,omitempty"`
+}
+
+//GetReplication gets a replication document
+func (c *Client) GetReplication(ctx context.Context, id string) (ReplicationDoc, error) {
+	var doc ReplicationDoc
+	err := c.GetDocument(ctx, ReplicatorDB, id, &doc)
+	return doc, err
+}
+
+//PostReplication creates a replication document
+func (c *Client) PostReplication(ctx context.Context, doc ReplicationDoc) error {
+	_, err := c.PostDocument(ctx, ReplicatorDB, doc)
+	return err
+}
+
+//DeleteReplication deletes the current revision of a replication document
+func (c *Client) DeleteReplication(ctx context.Context, id string) error {
+	return c.DeleteDocument(ctx, ReplicatorDB, id, "")
+}
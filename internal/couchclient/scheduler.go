@@ -0,0 +1,125 @@
+package couchclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+//SchedulerDoc is the replication scheduler's view of a replication document
+type SchedulerDoc struct {
+	Database    string      `json:"database"`
+	DocID       string      `json:"doc_id"`
+	ID          string      `json:"id"`
+	Source      string      `json:"source"`
+	Target      string      `json:"target"`
+	State       string      `json:"state"`
+	ErrorCount  int         `json:"error_count"`
+	StartTime   time.Time   `json:"start_time"`
+	LastUpdated time.Time   `json:"last_updated"`
+	Info        interface{} `json:"info,omitempty"`
+}
+
+//GetSchedulerDoc gets the scheduler's state for a replication document
+func (c *Client) GetSchedulerDoc(ctx context.Context, id string) (SchedulerDoc, error) {
+	var doc SchedulerDoc
+	err := c.do(ctx, http.MethodGet, "_scheduler/docs/"+ReplicatorDB+"/"+escape(id), nil, &doc)
+	return doc, err
+}
+
+//SchedulerDocs gets the scheduler's state for every replication document, keyed by document id
+func (c *Client) SchedulerDocs(ctx context.Context) (map[string]SchedulerDoc, error) {
+	var resp struct {
+		Docs []SchedulerDoc `json:"docs"`
+	}
+
+	if err := c.do(ctx, http.MethodGet, "_scheduler/docs/"+ReplicatorDB, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	toReturn := make(map[string]SchedulerDoc, len(resp.Docs))
+	for _, doc := range resp.Docs {
+		toReturn[doc.DocID] = doc
+	}
+
+	return toReturn, nil
+}
+
+//SchedulerJob is a replication job the scheduler is running, from _scheduler/jobs
+type SchedulerJob struct {
+	Database  string           `json:"database"`
+	DocID     string           `json:"doc_id"`
+	ID        string           `json:"id"`
+	PID       string           `json:"pid"`
+	Node      string           `json:"node"`
+	Source    string           `json:"source"`
+	Target    string           `json:"target"`
+	StartTime time.Time        `json:"start_time"`
+	History   []SchedulerEvent `json:"history"`
+	Info      interface{}      `json:"info,omitempty"`
+}
+
+//SchedulerEvent is a change in a scheduler job's state
+type SchedulerEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+//ReplicationInfo is the progress couch 3 reports in the info of scheduler docs and jobs
+type ReplicationInfo struct {
+	DocsRead              int         `json:"docs_read"`
+	DocsWritten           int         `json:"docs_written"`
+	DocWriteFailures      int         `json:"doc_write_failures"`
+	MissingRevisionsFound int         `json:"missing_revisions_found"`
+	RevisionsChecked      int         `json:"revisions_checked"`
+	ChangesPending        *int        `json:"changes_pending"`
+	SourceSeq             interface{} `json:"source_seq"`
+	CheckpointedSourceSeq interface{} `json:"checkpointed_source_seq"`
+	ThroughSeq            interface{} `json:"through_seq"`
+	Error                 string      `json:"error,omitempty"`
+}
+
+//ParseReplicationInfo reads the info of a scheduler doc or job. Older versions of couch only put an error message
+//there, so ok is false if it isn't an object.
+func ParseReplicationInfo(info interface{}) (ReplicationInfo, bool) {
+	var toReturn ReplicationInfo
+
+	if _, ok := info.(map[string]interface{}); !ok {
+		return toReturn, false
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return toReturn, false
+	}
+
+	if err := json.Unmarshal(b, &toReturn); err != nil {
+		return toReturn, false
+	}
+
+	return toReturn, true
+}
+
+type schedulerJobs struct {
+	TotalRows int            `json:"total_rows"`
+	Jobs      []SchedulerJob `json:"jobs"`
+}
+
+//SchedulerJobs gets the replication jobs the scheduler is running, keyed by the id of their replication document
+func (c *Client) SchedulerJobs(ctx context.Context) (map[string]SchedulerJob, error) {
+	var resp schedulerJobs
+	if err := c.do(ctx, http.MethodGet, "_scheduler/jobs", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	toReturn := make(map[string]SchedulerJob)
+	for _, job := range resp.Jobs {
+		if job.Database == ReplicatorDB && len(job.DocID) > 0 {
+			toReturn[job.DocID] = job
+		}
+	}
+
+	return toReturn, nil
+}
@@ -0,0 +1,52 @@
+package couchclient
+
+import (
+	"context"
+	"net/http"
+)
+
+//ActiveTask is a task from _active_tasks. Only the fields used by replication tasks are included.
+type ActiveTask struct {
+	Type                  string      `json:"type"`
+	Node                  string      `json:"node"`
+	PID                   string      `json:"pid"`
+	DocID                 string      `json:"doc_id"`
+	ReplicationID         string      `json:"replication_id"`
+	Source                string      `json:"source"`
+	Target                string      `json:"target"`
+	Continuous            bool        `json:"continuous"`
+	DocsRead              int         `json:"docs_read"`
+	DocsWritten           int         `json:"docs_written"`
+	DocWriteFailures      int         `json:"doc_write_failures"`
+	MissingRevisionsFound int         `json:"missing_revisions_found"`
+	RevisionsChecked      int         `json:"revisions_checked"`
+	ChangesPending        *int        `json:"changes_pending"`
+	SourceSeq             interface{} `json:"source_seq"`
+	CheckpointedSourceSeq interface{} `json:"checkpointed_source_seq"`
+	StartedOn             int64       `json:"started_on"`
+	UpdatedOn             int64       `json:"updated_on"`
+}
+
+//ActiveTasks gets the tasks currently running on the couch server
+func (c *Client) ActiveTasks(ctx context.Context) ([]ActiveTask, error) {
+	var tasks []ActiveTask
+	err := c.do(ctx, http.MethodGet, "_active_tasks", nil, &tasks)
+	return tasks, err
+}
+
+//ReplicationTasks gets the active replication tasks, keyed by the id of their replication document
+func (c *Client) ReplicationTasks(ctx context.Context) (map[string]ActiveTask, error) {
+	tasks, err := c.ActiveTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	toReturn := make(map[string]ActiveTask)
+	for _, task := range tasks {
+		if task.Type == "replication" && len(task.DocID) > 0 {
+			toReturn[task.DocID] = task
+		}
+	}
+
+	return toReturn, nil
+}
@@ -0,0 +1,117 @@
+package couchclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+//TLSOptions are the files and pins used to build a tls config for a couch server
+type TLSOptions struct {
+	//CAFile is a pem bundle of the certificate authorities to trust instead of the system's
+	CAFile string
+
+	//CertFile and KeyFile are the client certificate and key to present to the server
+	CertFile string
+	KeyFile  string
+
+	//Pins are base64 sha256 hashes of the subject public key info of certificates, optionally prefixed with
+	//sha256/. If any are given, the server's chain must include one of them.
+	Pins []string
+}
+
+//Enabled reports whether any of the options are set
+func (o TLSOptions) Enabled() bool {
+	return len(o.CAFile) > 0 || len(o.CertFile) > 0 || len(o.KeyFile) > 0 || len(o.Pins) > 0
+}
+
+//NewTLSConfig builds a tls config from o
+func NewTLSConfig(o TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(o.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %v", o.CAFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if len(o.CertFile) > 0 || len(o.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		pins := make([][]byte, 0, len(o.Pins))
+		for _, pin := range o.Pins {
+			b, err := ParsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, b)
+		}
+
+		config.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	return config, nil
+}
+
+//ParsePin decodes a certificate pin
+func ParsePin(pin string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate pin %q, it should be a base64 sha256 hash", pin)
+	}
+
+	return b, nil
+}
+
+//verifyPins checks that one of the certificates in a chain that was verified matches one of pins. It runs after the
+//normal verification of the chain, and only looks at the chains it built, since the server can present any
+//certificates it wants alongside its own.
+func verifyPins(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+		}
+
+		return errors.New("no verified certificate of the server matches a pinned certificate")
+	}
+}
+
+//WithTLSConfig sets the tls config used to connect to the server
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config
+		c.http.Transport = t
+	}
+}
@@ -0,0 +1,122 @@
+package couchclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func pinOf(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//caFile writes the certificate of srv to a file, so it can be trusted
+func caFile(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "couchclient")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	path := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return path
+}
+
+func TestPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`)) // nolint:errcheck
+	}))
+	defer srv.Close()
+
+	ca := caFile(t, srv)
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{"no pins", nil, true},
+		{"pinned", []string{pinOf(srv.Certificate())}, true},
+		{"pinned with a prefix", []string{other, "sha256/" + pinOf(srv.Certificate())}, true},
+		{"not pinned", []string{other}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := NewTLSConfig(TLSOptions{CAFile: ca, Pins: tt.pins})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = New(srv.URL, "", "", WithTLSConfig(config)).Ping(context.Background())
+			if tt.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("got no error from a server that isn't pinned")
+			}
+		})
+	}
+}
+
+//a server can present a pinned certificate it doesn't have the key for, so only the verified chains count
+func TestPinsIgnoreUnverifiedCertificates(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	pinned := srv.Certificate()
+	pin, err := ParsePin(pinOf(pinned))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("someone else's key")}
+	verify := verifyPins([][]byte{pin})
+
+	if err := verify([][]byte{other.Raw, pinned.Raw}, [][]*x509.Certificate{{other}}); err == nil {
+		t.Errorf("got no error when the pinned certificate was only presented")
+	}
+
+	if err := verify([][]byte{pinned.Raw}, nil); err == nil {
+		t.Errorf("got no error without a verified chain")
+	}
+
+	if err := verify(nil, [][]*x509.Certificate{{other, pinned}}); err != nil {
+		t.Errorf("unexpected error with the pinned certificate in the verified chain: %v", err)
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for _, pin := range []string{valid, "sha256/" + valid, " " + valid + " "} {
+		if _, err := ParsePin(pin); err != nil {
+			t.Errorf("unexpected error parsing %q: %v", pin, err)
+		}
+	}
+
+	for _, pin := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+		if _, err := ParsePin(pin); err == nil {
+			t.Errorf("got no error parsing %q", pin)
+		}
+	}
+}
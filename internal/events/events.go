@@ -0,0 +1,97 @@
+//Package events publishes what the replication scheduler is doing to other systems.
+package events
+
+import (
+	"context"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+)
+
+//Types of events
+const (
+	//TypeTransition is sent when a replication changes state
+	TypeTransition = "transition"
+
+	//TypeScheduleError is sent when a replication couldn't be scheduled
+	TypeScheduleError = "schedule_error"
+
+	//TypeConfigReload is sent when a changed config is applied
+	TypeConfigReload = "config_reload"
+)
+
+//Event is something that happened to a replication or the config
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Hostname    string    `json:"hostname"`
+	Building    string    `json:"building,omitempty"`
+	Room        string    `json:"room,omitempty"`
+	Database    string    `json:"database,omitempty"`
+	Replication string    `json:"replication,omitempty"`
+	OldState    string    `json:"old_state,omitempty"`
+	NewState    string    `json:"new_state,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+//Sink is somewhere events are sent
+type Sink interface {
+	//Send delivers e, returning once it's been delivered or ctx is done
+	Send(ctx context.Context, e Event) error
+
+	//Name describes the sink in logs
+	Name() string
+}
+
+//how long a sink has to take an event before it's given up on
+const sendTimeout = 10 * time.Second
+
+//Publisher sends events to each of its sinks in the background, so a slow sink doesn't hold up the scheduler
+type Publisher struct {
+	sinks []Sink
+	queue chan Event
+}
+
+//NewPublisher builds a publisher that holds up to size events while its sinks catch up
+func NewPublisher(size int, sinks ...Sink) *Publisher {
+	return &Publisher{
+		sinks: sinks,
+		queue: make(chan Event, size),
+	}
+}
+
+//Publish queues e to be sent. If the queue is full e is dropped.
+func (p *Publisher) Publish(e Event) {
+	if len(p.sinks) == 0 {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	select {
+	case p.queue <- e:
+	default:
+		l.L.Warnf("Dropping %v event for %v, the event queue is full", e.Type, e.Database)
+	}
+}
+
+//Run sends queued events until ctx is cancelled
+func (p *Publisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-p.queue:
+			for _, s := range p.sinks {
+				sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+				if err := s.Send(sendCtx, e); err != nil {
+					l.L.Warnf("Couldn't send %v event to %v: %v", e.Type, s.Name(), err)
+				}
+				cancel()
+			}
+		}
+	}
+}
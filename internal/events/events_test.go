@@ -0,0 +1,104 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+//recordingSink keeps every event it's sent, and fails every send if err is set
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Send(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+	return s.err
+}
+
+func (s *recordingSink) sent() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Event{}, s.events...)
+}
+
+//waitFor waits for s to have been sent n events
+func (s *recordingSink) waitFor(t *testing.T, n int) []Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := s.sent()
+		if len(events) >= n {
+			return events
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v events, want %v", len(events), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublisherDropsWhenFull(t *testing.T) {
+	failing := &recordingSink{err: errors.New("sink is down")}
+	sink := &recordingSink{}
+	p := NewPublisher(2, failing, sink)
+
+	for _, db := range []string{"a", "b", "c"} {
+		p.Publish(Event{Type: TypeTransition, Database: db})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	events := sink.waitFor(t, 2)
+	if len(events) != 2 || events[0].Database != "a" || events[1].Database != "b" {
+		t.Fatalf("got %+v, want the first two events in order and the third dropped", events)
+	}
+
+	for _, e := range events {
+		if e.Time.IsZero() {
+			t.Errorf("got %+v, want its time filled in", e)
+		}
+	}
+
+	//a sink failing doesn't keep events from the others
+	if n := len(failing.sent()); n != 2 {
+		t.Errorf("got %v events sent to the failing sink, want 2", n)
+	}
+
+	p.Publish(Event{Type: TypeConfigReload})
+	if events := sink.waitFor(t, 3); events[2].Type != TypeConfigReload {
+		t.Errorf("got %+v, want events queued while running to be sent", events[2])
+	}
+}
+
+func TestPublisherWithoutSinks(t *testing.T) {
+	p := NewPublisher(1)
+	p.Publish(Event{Type: TypeTransition})
+
+	if len(p.queue) != 0 {
+		t.Errorf("got %v events queued with nowhere to send them", len(p.queue))
+	}
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//how many times an event is retried by default, and how long to wait before the first retry
+const (
+	defaultRetries    = 2
+	defaultRetryDelay = 1 * time.Second
+)
+
+//HTTPSink posts each event as JSON to a url. Events that fail because of a network error or a 5xx or 429 response
+//are retried up to Retries times, waiting RetryDelay before the first retry and twice as long before each one after.
+type HTTPSink struct {
+	URL        string
+	Client     *http.Client
+	Retries    int
+	RetryDelay time.Duration
+}
+
+//NewHTTPSink builds a sink that posts to url
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		URL:        url,
+		Client:     &http.Client{},
+		Retries:    defaultRetries,
+		RetryDelay: defaultRetryDelay,
+	}
+}
+
+//Name is the url events are posted to
+func (s *HTTPSink) Name() string {
+	return s.URL
+}
+
+//Send posts e to the url, retrying until it's delivered, it fails in a way that won't be fixed by retrying, or ctx is
+//done. Anything other than a 2xx response is an error.
+func (s *HTTPSink) Send(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %w", err)
+	}
+
+	delay := s.RetryDelay
+	for try := 0; ; try++ {
+		retry, err := s.post(ctx, b)
+		if err == nil || !retry || try >= s.Retries {
+			return err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+//post sends an event once, reporting whether it's worth trying again if it fails
+func (s *HTTPSink) post(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return false, fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("unable to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("%v responded with %v", s.URL, resp.Status)
+	}
+
+	return false, nil
+}
+
+//FileSink appends each event to a file as a line of JSON
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+//NewFileSink opens path to append events to, creating it if it doesn't exist
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open event file: %w", err)
+	}
+
+	return &FileSink{
+		path: path,
+		file: f,
+	}, nil
+}
+
+//Name is the path of the file
+func (s *FileSink) Name() string {
+	return s.path
+}
+
+//Send appends e to the file
+func (s *FileSink) Send(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("unable to write event: %w", err)
+	}
+
+	return nil
+}
+
+//Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,130 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		ok       bool
+		posts    int32
+	}{
+		{name: "delivered", statuses: []int{http.StatusNoContent}, ok: true, posts: 1},
+		{name: "retried after a 5xx", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, ok: true, posts: 3},
+		{name: "retried after a 429", statuses: []int{http.StatusTooManyRequests, http.StatusAccepted}, ok: true, posts: 2},
+		{name: "out of retries", statuses: []int{http.StatusInternalServerError}, posts: 3},
+		{name: "not retried after a 4xx", statuses: []int{http.StatusBadRequest}, posts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var posts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&posts, 1)
+
+				var e Event
+				if err := json.NewDecoder(r.Body).Decode(&e); err != nil || e.Database != "devices" {
+					t.Errorf("got event %+v and error %v, want the event posted", e, err)
+				}
+
+				status := tt.statuses[len(tt.statuses)-1]
+				if int(n) <= len(tt.statuses) {
+					status = tt.statuses[n-1]
+				}
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			sink := NewHTTPSink(srv.URL)
+			sink.RetryDelay = time.Millisecond
+
+			err := sink.Send(context.Background(), Event{Type: TypeTransition, Database: "devices"})
+			switch {
+			case tt.ok && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !tt.ok && err == nil:
+				t.Errorf("got no error")
+			}
+
+			if got := atomic.LoadInt32(&posts); got != tt.posts {
+				t.Errorf("got %v posts, want %v", got, tt.posts)
+			}
+		})
+	}
+}
+
+func TestHTTPSinkUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	sink := NewHTTPSink(srv.URL)
+	sink.RetryDelay = time.Hour
+
+	//the retry is given up on when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := sink.Send(ctx, Event{Type: TypeTransition}); err == nil {
+		t.Errorf("got no error from a server that's down")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	if err := ioutil.WriteFile(path, []byte(`{"type":"existing"}`+"\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, db := range []string{"devices", "rooms"} {
+		if err := sink.Send(context.Background(), Event{Type: TypeTransition, Database: db}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	var got []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q isn't an event: %v", scanner.Text(), err)
+		}
+		got = append(got, e)
+	}
+
+	if len(got) != 3 || got[0].Type != "existing" || got[1].Database != "devices" || got[2].Database != "rooms" {
+		t.Errorf("got %+v, want the events appended after what was already there", got)
+	}
+}
@@ -0,0 +1,154 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	l "github.com/byuoitav/common/log"
+	"golang.org/x/net/websocket"
+)
+
+//how many events a stream client can fall behind by before it's disconnected
+const clientBuffer = 64
+
+//Stream sends events to every websocket client connected to it
+type Stream struct {
+	mu      sync.Mutex
+	clients map[chan Event]bool
+	origins map[string]bool
+}
+
+//NewStream builds a stream with no clients
+func NewStream() *Stream {
+	return &Stream{
+		clients: make(map[chan Event]bool),
+	}
+}
+
+//Name describes the stream
+func (s *Stream) Name() string {
+	return "the websocket stream"
+}
+
+//AllowOrigins lets browsers connect from origins (e.g. https://dashboard:8080), as well as from the stream's own host
+func (s *Stream) AllowOrigins(origins []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.origins = make(map[string]bool)
+	for _, origin := range origins {
+		s.origins[strings.ToLower(strings.TrimRight(origin, "/"))] = true
+	}
+}
+
+//Send gives e to every connected client. Clients that have fallen too far behind are disconnected instead.
+func (s *Stream) Send(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c <- e:
+		default:
+			delete(s.clients, c)
+			close(c)
+		}
+	}
+
+	return nil
+}
+
+func (s *Stream) add() chan Event {
+	c := make(chan Event, clientBuffer)
+
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+
+	return c
+}
+
+func (s *Stream) remove(c chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c)
+	}
+}
+
+//ServeHTTP upgrades the request to a websocket, and sends each event to it as JSON until the client disconnects
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server := websocket.Server{
+		Handshake: s.checkOrigin,
+		Handler:   s.serve,
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+//checkOrigin only accepts connections from the stream's own host or an allowed origin, so other sites can't open
+//the stream from a browser on the network
+func (s *Stream) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+
+	if origin == nil {
+		return fmt.Errorf("null origin")
+	}
+
+	config.Origin = origin
+	if strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.origins[strings.ToLower(origin.Scheme+"://"+origin.Host)] {
+		return fmt.Errorf("origin %v isn't allowed", origin)
+	}
+
+	return nil
+}
+
+func (s *Stream) serve(ws *websocket.Conn) {
+	defer ws.Close()
+
+	c := s.add()
+	defer s.remove(c)
+
+	l.L.Debugf("Event stream client connected from %v", ws.Request().RemoteAddr)
+
+	//clients don't send anything, so a failed read means they've gone away
+	gone := make(chan struct{})
+	go func() {
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+		}
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-gone:
+			l.L.Debugf("Event stream client %v disconnected", ws.Request().RemoteAddr)
+			return
+		case e, ok := <-c:
+			if !ok {
+				l.L.Warnf("Disconnecting event stream client %v, it fell too far behind", ws.Request().RemoteAddr)
+				return
+			}
+
+			if err := websocket.JSON.Send(ws, e); err != nil {
+				return
+			}
+		}
+	}
+}
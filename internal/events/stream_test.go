@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func (s *Stream) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+func TestStreamFanOut(t *testing.T) {
+	s := NewStream()
+	a, b := s.add(), s.add()
+
+	if err := s.Send(context.Background(), Event{Type: TypeTransition, Database: "devices"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, c := range []chan Event{a, b} {
+		if e := <-c; e.Database != "devices" {
+			t.Errorf("got %+v, want the event sent to every client", e)
+		}
+	}
+
+	s.remove(a)
+	s.remove(a)
+	if _, ok := <-a; ok {
+		t.Errorf("got an event from a client that was removed")
+	}
+
+	s.Send(context.Background(), Event{Type: TypeConfigReload}) // nolint:errcheck
+	if e := <-b; e.Type != TypeConfigReload {
+		t.Errorf("got %+v, want the remaining client to still get events", e)
+	}
+	if n := s.clientCount(); n != 1 {
+		t.Errorf("got %v clients, want 1", n)
+	}
+}
+
+func TestStreamDropsSlowClients(t *testing.T) {
+	s := NewStream()
+	slow := s.add()
+
+	for i := 0; i <= clientBuffer; i++ {
+		s.Send(context.Background(), Event{Type: TypeTransition}) // nolint:errcheck
+	}
+
+	if n := s.clientCount(); n != 0 {
+		t.Fatalf("got %v clients, want the slow one dropped", n)
+	}
+
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != clientBuffer {
+		t.Errorf("got %v events before the client was closed, want %v", n, clientBuffer)
+	}
+}
+
+func TestStreamWebsocket(t *testing.T) {
+	s := NewStream()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitForClients(t, s, 1)
+
+	if err := s.Send(context.Background(), Event{Type: TypeTransition, Database: "devices"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var e Event
+	ws.SetDeadline(time.Now().Add(5 * time.Second)) // nolint:errcheck
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Database != "devices" {
+		t.Errorf("got %+v, want the event sent over the websocket", e)
+	}
+
+	//the client is unsubscribed when it disconnects
+	ws.Close()
+	waitForClients(t, s, 0)
+}
+
+func TestStreamOrigins(t *testing.T) {
+	s := NewStream()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, err := websocket.Dial(url, "", "http://elsewhere:8080"); err == nil {
+		t.Fatalf("got a connection from an origin that isn't allowed")
+	}
+
+	s.AllowOrigins([]string{"http://Elsewhere:8080/"})
+	ws, err := websocket.Dial(url, "", "http://elsewhere:8080")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ws.Close()
+}
+
+func waitForClients(t *testing.T, s *Stream, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.clientCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v clients, want %v", s.clientCount(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,116 @@
+//Package redact removes credentials from strings before they're logged.
+package redact
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+//Mask replaces anything that's redacted
+const Mask = "*****"
+
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	//user:pass@ and user@ in urls
+	{regexp.MustCompile(`://[^/\s:@]+:[^@\s/]*@`), "://" + Mask + ":" + Mask + "@"},
+	{regexp.MustCompile(`://[^/\s:@]+@`), "://" + Mask + "@"},
+
+	//authorization headers
+	{regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?(?:basic|bearer)\s+)[A-Za-z0-9+/=._~-]+`), "${1}" + Mask},
+
+	//password and token looking fields
+	{regexp.MustCompile(`(?i)("?\b(?:password|passwd|pass|secret|token)"?\s*[:=]\s*"?)[^"\s,&}]+`), "${1}" + Mask},
+	//the usernames that go with them
+	{regexp.MustCompile(`(?i)("?\b(?:username|user)"?\s*[:=]\s*"?)[^"\s,&}]+`), "${1}" + Mask},
+}
+
+var (
+	secretsMu sync.RWMutex
+	secrets   = make(map[string]bool)
+)
+
+//MinSecretLength is the shortest secret AddSecret will redact, since shorter values would redact too much
+//unrelated text
+const MinSecretLength = 4
+
+//AddSecret makes s get redacted wherever it shows up. It reports whether it will be, which it won't if s is shorter
+//than MinSecretLength.
+func AddSecret(s string) bool {
+	if len(s) < MinSecretLength {
+		return false
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	secrets[s] = true
+	return true
+}
+
+//String redacts credentials from s
+func String(s string) string {
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	for secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+
+	return s
+}
+
+//Logger wraps l so that everything it logs is redacted
+func Logger(l *zap.SugaredLogger) *zap.SugaredLogger {
+	return l.Desugar().WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return core{c}
+	})).Sugar()
+}
+
+//core redacts the message and string fields of each entry before passing it on
+type core struct {
+	zapcore.Core
+}
+
+func (c core) With(fields []zapcore.Field) zapcore.Core {
+	return core{c.Core.With(redactFields(fields))}
+}
+
+func (c core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c core) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = String(e.Message)
+	return c.Core.Write(e, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	toReturn := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = String(f.String)
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, String(err.Error()))
+			}
+		}
+		toReturn[i] = f
+	}
+
+	return toReturn
+}
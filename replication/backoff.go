@@ -0,0 +1,111 @@
+package replication
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//RetryPolicy controls how long to wait before retrying a replication that couldn't be scheduled. Each failure
+//multiplies the delay by Multiplier, up to MaxDelay, and Jitter randomly spreads each delay by up to that fraction
+//of it so that hosts don't retry in lockstep. A Jitter of 0 turns jitter off.
+type RetryPolicy struct {
+	InitialDelay int      `json:"initial_delay,omitempty"`
+	Multiplier   float64  `json:"multiplier,omitempty"`
+	MaxDelay     int      `json:"max_delay,omitempty"`
+	Jitter       *float64 `json:"jitter,omitempty"`
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+//DefaultRetryPolicy is used for any values not set in a database's retry policy
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: 30,
+	Multiplier:   2,
+	MaxDelay:     600,
+	Jitter:       float64Ptr(0.2),
+}
+
+var jitterRand = struct {
+	sync.Mutex
+	r *rand.Rand
+}{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+//withDefaults fills in unset or invalid values from the default policy
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = DefaultRetryPolicy.InitialDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.MaxDelay < p.InitialDelay {
+		p.MaxDelay = p.InitialDelay
+	}
+	if p.Jitter == nil || *p.Jitter < 0 || *p.Jitter > 1 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+
+	return p
+}
+
+//backoff grows a delay each time it's used, up to a maximum
+type backoff struct {
+	policy RetryPolicy
+	cur    time.Duration
+}
+
+func newBackoff(policy RetryPolicy) *backoff {
+	return &backoff{
+		policy: policy.withDefaults(),
+	}
+}
+
+//newStartupBackoff is used while waiting on things the service can't start without
+func newStartupBackoff() *backoff {
+	return newBackoff(RetryPolicy{
+		InitialDelay: 10,
+		Multiplier:   2,
+		MaxDelay:     300,
+	})
+}
+
+//next returns how long to wait before the next attempt
+func (b *backoff) next() time.Duration {
+	max := time.Duration(b.policy.MaxDelay) * time.Second
+
+	if b.cur == 0 {
+		b.cur = time.Duration(b.policy.InitialDelay) * time.Second
+	} else {
+		b.cur = time.Duration(float64(b.cur) * b.policy.Multiplier)
+	}
+
+	if b.cur > max {
+		b.cur = max
+	}
+
+	return jitter(b.cur, *b.policy.Jitter)
+}
+
+//reset starts the delay over at the initial value
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+//jitter randomly moves d by up to +/- fraction of itself
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+
+	jitterRand.Lock()
+	f := jitterRand.r.Float64()
+	jitterRand.Unlock()
+
+	return time.Duration(float64(d) * (1 + fraction*(2*f-1)))
+}
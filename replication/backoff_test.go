@@ -0,0 +1,97 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(RetryPolicy{InitialDelay: 10, Multiplier: 3, MaxDelay: 100, Jitter: float64Ptr(0)})
+
+	want := []time.Duration{10 * time.Second, 30 * time.Second, 90 * time.Second, 100 * time.Second, 100 * time.Second}
+	for i, w := range want {
+		if got := b.next(); got != w {
+			t.Errorf("got delay %v for attempt %v, want %v", got, i+1, w)
+		}
+	}
+
+	b.reset()
+	if got := b.next(); got != 10*time.Second {
+		t.Errorf("got delay %v after a reset, want 10s", got)
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	b := newBackoff(RetryPolicy{Multiplier: 0.5, MaxDelay: 5, Jitter: float64Ptr(2)})
+
+	if b.policy.InitialDelay != DefaultRetryPolicy.InitialDelay || b.policy.Multiplier != DefaultRetryPolicy.Multiplier {
+		t.Errorf("got policy %+v, want the defaults filled in", b.policy)
+	}
+	if b.policy.MaxDelay != b.policy.InitialDelay {
+		t.Errorf("got max delay %v, want it raised to the initial delay %v", b.policy.MaxDelay, b.policy.InitialDelay)
+	}
+	if *b.policy.Jitter != *DefaultRetryPolicy.Jitter {
+		t.Errorf("got jitter %v, want the default for one out of range", *b.policy.Jitter)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 100 * time.Second
+	min, max := 80*time.Second, 120*time.Second
+
+	spread := false
+	for i := 0; i < 1000; i++ {
+		got := jitter(d, 0.2)
+		if got < min || got > max {
+			t.Fatalf("got %v, want it within %v and %v", got, min, max)
+		}
+		if got != d {
+			spread = true
+		}
+	}
+
+	if !spread {
+		t.Errorf("jitter never moved the delay")
+	}
+
+	if got := jitter(d, 0); got != d {
+		t.Errorf("got %v with no jitter, want %v", got, d)
+	}
+}
+
+func TestPreviousRun(t *testing.T) {
+	couch := newFakeCouch(t)
+	useLocalCouch(t, couch)
+
+	tests := []struct {
+		name       string
+		continuous bool
+		states     map[string]string
+		succeeded  bool
+		failed     bool
+	}{
+		{name: "not started", states: map[string]string{}},
+		{name: "completed", states: map[string]string{"auto_db": "completed", "auto_db_push": "completed"}, succeeded: true},
+		{name: "one running", states: map[string]string{"auto_db": "completed", "auto_db_push": "running"}},
+		{name: "continuous running", continuous: true, states: map[string]string{"auto_db": "running", "auto_db_push": "running"}, succeeded: true},
+		{name: "continuous pending", continuous: true, states: map[string]string{"auto_db": "running", "auto_db_push": "pending"}},
+		{name: "crashing", continuous: true, states: map[string]string{"auto_db": "running", "auto_db_push": "crashing"}, failed: true},
+		{name: "failed", states: map[string]string{"auto_db": "failed", "auto_db_push": "completed"}, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			couch.clearStates()
+			for id, state := range tt.states {
+				couch.setState(id, state)
+			}
+
+			config := DatabaseConfig{Database: "db", Direction: DirectionBoth, Continuous: tt.continuous}
+			succeeded, failed := previousRun(context.Background(), config)
+			if succeeded != tt.succeeded || failed != tt.failed {
+				t.Errorf("got succeeded %v and failed %v, want %v and %v", succeeded, failed, tt.succeeded, tt.failed)
+			}
+		})
+	}
+}
@@ -1,17 +1,37 @@
 package replication
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"regexp"
-	"strings"
 
-	"github.com/byuoitav/common/jsonhttp"
 	l "github.com/byuoitav/common/log"
 	"github.com/byuoitav/common/nerr"
 )
 
 const (
-	REPL_CONFIG_DB = "replication-config"
+	REPL_CONFIG_DB  = "replication-config"
+	REPL_HISTORY_DB = "replication-history"
+)
+
+//Merge strategies for a database in a config that's layered on top of another
+const (
+	//MergeOverride adds the database, replacing any inherited config for it. This is the default.
+	MergeOverride = "override"
+
+	//MergeAdd adds the database only if it wasn't inherited
+	MergeAdd = "add"
+
+	//MergeRemove removes an inherited database
+	MergeRemove = "remove"
+)
+
+//Replication directions, relative to this host
+const (
+	DirectionPull = "pull"
+	DirectionPush = "push"
+	DirectionBoth = "both"
 )
 
 type ReplicationConfig struct {
@@ -23,80 +43,212 @@ type ReplicationConfig struct {
 type HostConfig struct {
 	Hostname     string           `json:"hostname"`
 	Replications []DatabaseConfig `json:"replications,omitempty"`
+
+	//Replace discards the databases inherited from less specific config documents
+	Replace bool `json:"replace,omitempty"`
 }
 
 type DatabaseConfig struct {
 	Database   string `json:"database"`
 	Continuous bool   `json:"continuous"`
 	Interval   int    `json:"interval,omitempty"`
+
+	//Merge is how this database is combined with the config inherited from less specific config documents
+	Merge string `json:"merge,omitempty"`
+
+	//Direction is one of pull (the default), push, or both
+	Direction string `json:"direction,omitempty"`
+
+	//Retry is the policy for retrying failed replications. Unset values use DefaultRetryPolicy.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	//Schedule is a cron expression to run on instead of Interval, and QuietWindows are times the replication
+	//won't be started. Both are evaluated in Timezone, or the local timezone if it isn't set.
+	Schedule     string       `json:"schedule,omitempty"`
+	QuietWindows []TimeWindow `json:"quiet_windows,omitempty"`
+	Timezone     string       `json:"timezone,omitempty"`
+
+	//Selector is a mango selector used to limit which documents are replicated. String values may
+	//contain the {{building}}, {{room}}, {{device}} and {{hostname}} template variables.
+	Selector map[string]interface{} `json:"selector,omitempty"`
+
+	//Filter is the name of a design doc filter (ddoc/filter) to use instead of a selector, with
+	//QueryParams passed to it. QueryParams values may contain the same template variables.
+	Filter      string            `json:"filter,omitempty"`
+	QueryParams map[string]string `json:"query_params,omitempty"`
+
+	//SourceDatabase and TargetDatabase are the names of the database on the remote and local servers, if they aren't
+	//Database. SourceServer is the name of the remote source or alias to always replicate with, instead of the
+	//active source.
+	SourceDatabase string `json:"source_database,omitempty"`
+	TargetDatabase string `json:"target_database,omitempty"`
+	SourceServer   string `json:"source_server,omitempty"`
 }
 
-func GetConfig(hostname string) (HostConfig, *nerr.E) {
-	toReturn := HostConfig{}
+//configDocIDs are the ids of the documents in the config database that can affect the config for hostname, from
+//least to most specific. A hostname that can't be parsed only gets the default and host documents.
+func configDocIDs(hostname string) []string {
+	ids := []string{"default"}
+	candidates := []string{hostname}
 
-	l.L.Debugf("Looking for a config for %v", hostname)
+	host, err := ParseHostname(hostname)
+	if err != nil {
+		l.L.Debugf("Only using the default and host config documents: %v", err.Error())
+	} else {
+		candidates = []string{host.Building, hostname}
+		if len(host.Room) > 0 {
+			candidates = []string{host.Building, fmt.Sprintf("%v-%v", host.Building, host.Room), hostname}
+		}
+	}
 
-	//get the room config
-	splitver := strings.Split(hostname, "-")
+	for _, id := range candidates {
+		if len(id) > 0 && id != ids[len(ids)-1] {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
 
-	//check to see if the room has one
-	config, err := GetConfigDoc(fmt.Sprintf("%v-%v", splitver[0], splitver[1]))
+//ConfigLayer is a rule from one of the config documents that was used to build a host's config
+type ConfigLayer struct {
+	Document string `json:"document"`
+	Rule     string `json:"rule"`
+}
+
+//ResolvedConfig is a host's config, along with the rules it was built from
+type ResolvedConfig struct {
+	Config HostConfig    `json:"config"`
+	Layers []ConfigLayer `json:"layers"`
+}
+
+//GetConfig builds the config for hostname by layering the matching rules from the default, building, room, and
+//host config documents.
+func GetConfig(ctx context.Context, hostname string) (HostConfig, *nerr.E) {
+	resolved, err := ResolveConfig(ctx, hostname)
 	if err != nil {
-		if err.Type == "*couch.NotFound" || strings.Contains(err.Error(), "not_found") {
-			l.L.Debug("Couldn't get a room specific configuration, getting the default")
-			//get the default
-			config, err = GetConfigDoc("default")
-			if err != nil {
-				return toReturn, nerr.Translate(err).Add("Couldn't get the default or room specific configuration")
+		return HostConfig{}, err
+	}
+
+	return resolved.Config, nil
+}
+
+//ResolveConfig builds the config for hostname, starting with the first matching rule in the default document and then
+//merging in the first matching rule from each of the building, room, and host documents that exist.
+func ResolveConfig(ctx context.Context, hostname string) (ResolvedConfig, *nerr.E) {
+	toReturn := ResolvedConfig{}
+
+	l.L.Debugf("Looking for a config for %v", hostname)
+
+	found := false
+	for _, id := range configDocIDs(hostname) {
+		config, err := GetConfigDoc(ctx, id)
+		if err != nil {
+			if err.Type == "not_found" {
+				l.L.Debugf("No %v configuration document", id)
+				continue
 			}
-		} else {
+
 			return toReturn, err.Add("Couldn't get the configuration")
 		}
+		found = true
+
+		//now we go through and check all of the rules one by one to see which matches my hostname
+		rule, ok, err := matchRule(config, hostname)
+		if err != nil {
+			return toReturn, err
+		}
 
+		if !ok {
+			continue
+		}
+
+		l.L.Debugf("Found rule %v in %v.", rule.Hostname, id)
+		toReturn.Config = mergeHostConfig(toReturn.Config, rule)
+		toReturn.Layers = append(toReturn.Layers, ConfigLayer{
+			Document: id,
+			Rule:     rule.Hostname,
+		})
+	}
+
+	if !found {
+		return toReturn, nerr.Create(fmt.Sprintf("Couldn't get the default or any specific configuration for %v", hostname), "not_found")
 	}
 
-	//now we go through and check all of the rules one by one to see which matches my hostname
+	if len(toReturn.Layers) == 0 {
+		return toReturn, nerr.Create(fmt.Sprintf("Couldn't match a rule for %v in any config", hostname), "not_found")
+	}
+
+	return toReturn, nil
+}
+
+//matchRule finds the first rule in config whose hostname regex matches hostname
+func matchRule(config ReplicationConfig, hostname string) (HostConfig, bool, *nerr.E) {
 	for _, rule := range config.Rules {
 		match, err := regexp.MatchString(rule.Hostname, hostname)
 		if err != nil {
-			return toReturn, nerr.Translate(err).Addf("Couldn't run regex %v to match hostname for config.", rule.Hostname)
+			return rule, false, nerr.Translate(err).Addf("Couldn't run regex %v to match hostname for config.", rule.Hostname)
 		}
 		if match {
-			l.L.Debugf("Found rule.")
-			return rule, nil
+			return rule, true, nil
 		}
 	}
 
-	return toReturn, nerr.Create(fmt.Sprintf("Couldn't match a rule for %v in the config %v", hostname, config.ID), "not-found")
+	return HostConfig{}, false, nil
 }
 
-func GetConfigDoc(id string) (ReplicationConfig, *nerr.E) {
-
-	l.L.Debugf("Getting config document %v", id)
+//mergeHostConfig applies a more specific rule on top of an inherited config. Each database in the rule is handled
+//according to its merge strategy, and a rule with replace set throws away everything inherited.
+func mergeHostConfig(inherited, rule HostConfig) HostConfig {
+	toReturn := HostConfig{
+		Hostname: rule.Hostname,
+	}
 
-	toReturn := ReplicationConfig{}
+	if !rule.Replace {
+		toReturn.Replications = append(toReturn.Replications, inherited.Replications...)
+	}
 
-	addr := fmt.Sprintf("%v/%v", REPL_CONFIG_DB, id)
-	l.L.Debugf("Sending request to %v", addr)
+	index := func(db string) int {
+		for i := range toReturn.Replications {
+			if toReturn.Replications[i].Database == db {
+				return i
+			}
+		}
+		return -1
+	}
 
-	/*db := couch.NewDB(os.Getenv("COUCH_ADDR"), os.Getenv("COUCH_USER"), os.Getenv("COUCH_PASS"))
-	err := db.MakeRequest("GET", fmt.Sprintf("%v/%v", REPL_CONFIG_DB, id), "application/json", []byte{}, &toReturn)
-	if err != nil {
-		return toReturn, nerr.Translate(err).Addf("Couldn't get the configuration document %v", id)
-	}*/
+	for _, c := range rule.Replications {
+		i := index(c.Database)
+		strategy := c.Merge
+		c.Merge = ""
 
-	headers := map[string]string{
-		"Authorization": "Basic " + jsonhttp.BasicAuth(COUCH_USER, COUCH_PASS),
+		switch strategy {
+		case MergeRemove:
+			if i >= 0 {
+				toReturn.Replications = append(toReturn.Replications[:i], toReturn.Replications[i+1:]...)
+			}
+		case MergeAdd:
+			if i < 0 {
+				toReturn.Replications = append(toReturn.Replications, c)
+			}
+		default:
+			if i >= 0 {
+				toReturn.Replications[i] = c
+			} else {
+				toReturn.Replications = append(toReturn.Replications, c)
+			}
+		}
 	}
 
-	_, _, err := jsonhttp.CreateAndExecuteJSONRequest("Retrieve Config Document "+id, "GET", fmt.Sprintf("%v/%v/%v", COUCH_ADDR, REPL_CONFIG_DB, id), "", headers, 30, &toReturn)
+	return toReturn
+}
 
-	if err != nil {
-		l.L.Debugf("Unable to retrieve config document %v", id)
-		return toReturn, nerr.Translate(err).Addf("Couldn't get the configuration document %v", id)
-	}
+//GetConfigDoc gets a config document from the configured ConfigProvider
+func GetConfigDoc(ctx context.Context, id string) (ReplicationConfig, *nerr.E) {
 
-	return toReturn, nil
+	l.L.Debugf("Getting config document %v", id)
+
+	return configProvider.GetConfigDoc(ctx, id)
 }
 
 func CheckHostConfigEquality(a, b HostConfig) bool {
@@ -122,5 +274,94 @@ func CheckDBConfigEquality(a, b DatabaseConfig) bool {
 	if a.Interval != b.Interval {
 		return false
 	}
+	if a.Direction != b.Direction || a.Filter != b.Filter || a.Schedule != b.Schedule || a.Timezone != b.Timezone {
+		return false
+	}
+	if !reflect.DeepEqual(a.Selector, b.Selector) || !reflect.DeepEqual(a.QueryParams, b.QueryParams) || !reflect.DeepEqual(a.Retry, b.Retry) {
+		return false
+	}
+	if !reflect.DeepEqual(a.QuietWindows, b.QuietWindows) {
+		return false
+	}
+	if !sameEndpoints(a, b) {
+		return false
+	}
 	return a.Continuous == b.Continuous
 }
+
+//sameDocuments reports whether a and b replicate the same documents in the same way
+func sameDocuments(a, b DatabaseConfig) bool {
+	return a.Continuous == b.Continuous && a.Filter == b.Filter && reflect.DeepEqual(a.Selector, b.Selector) &&
+		reflect.DeepEqual(a.QueryParams, b.QueryParams)
+}
+
+//sameEndpoints reports whether a and b replicate between the same databases and servers
+func sameEndpoints(a, b DatabaseConfig) bool {
+	return a.SourceDB() == b.SourceDB() && a.TargetDB() == b.TargetDB() && a.SourceServer == b.SourceServer
+}
+
+//SourceDB is the name of the database on the remote server
+func (c DatabaseConfig) SourceDB() string {
+	if len(c.SourceDatabase) > 0 {
+		return c.SourceDatabase
+	}
+
+	return c.Database
+}
+
+//TargetDB is the name of the database on the local server
+func (c DatabaseConfig) TargetDB() string {
+	if len(c.TargetDatabase) > 0 {
+		return c.TargetDatabase
+	}
+
+	return c.Database
+}
+
+//RetryPolicy returns the retry policy for the database
+func (c DatabaseConfig) RetryPolicy() RetryPolicy {
+	if c.Retry == nil {
+		return DefaultRetryPolicy
+	}
+
+	return c.Retry.withDefaults()
+}
+
+//Directions returns each direction a replication needs to be scheduled in
+func (c DatabaseConfig) Directions() ([]string, *nerr.E) {
+	switch c.Direction {
+	case "", DirectionPull:
+		return []string{DirectionPull}, nil
+	case DirectionPush:
+		return []string{DirectionPush}, nil
+	case DirectionBoth:
+		return []string{DirectionPull, DirectionPush}, nil
+	default:
+		return nil, nerr.Create(fmt.Sprintf("Invalid replication direction %v for %v", c.Direction, c.Database), "invalid_args")
+	}
+}
+
+//ReplicationIDs returns the id of the replication document for each direction of the config
+func (c DatabaseConfig) ReplicationIDs() []string {
+	directions, err := c.Directions()
+	if err != nil {
+		return nil
+	}
+
+	ids := make([]string, 0, len(directions))
+	for _, direction := range directions {
+		ids = append(ids, ReplicationID(c.Database, direction))
+	}
+
+	return ids
+}
+
+//ReplicationID returns the id of the replication document for a database in the given direction.
+//Pulls keep the original auto_<db> id so that existing documents are reused.
+func ReplicationID(db, direction string) string {
+	if direction == DirectionPush {
+		return fmt.Sprintf("auto_%v_push", db)
+	}
+
+	return fmt.Sprintf("auto_%v", db)
+}
@@ -0,0 +1,246 @@
+package replication
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+//useHostnamePattern parses hostnames with pattern for the rest of the test
+func useHostnamePattern(t *testing.T, pattern string) {
+	t.Helper()
+
+	p, err := NewRegexHostnameParser(pattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hostnameParser.RLock()
+	prev := hostnameParser.p
+	hostnameParser.RUnlock()
+
+	SetHostnameParser(p)
+	t.Cleanup(func() {
+		SetHostnameParser(prev)
+	})
+}
+
+//useConfigDocs reads config documents from data for the rest of the test
+func useConfigDocs(t *testing.T, data string) {
+	t.Helper()
+
+	p, err := NewStaticConfigProvider([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prev := configProvider
+	SetConfigProvider(p)
+	t.Cleanup(func() {
+		SetConfigProvider(prev)
+	})
+}
+
+func databases(config HostConfig) []string {
+	dbs := []string{}
+	for _, c := range config.Replications {
+		dbs = append(dbs, c.Database)
+	}
+
+	return dbs
+}
+
+func TestConfigDocIDs(t *testing.T) {
+	useHostnamePattern(t, `^(?P<building>[^-]+)-(?P<room>[^-]+)-(?P<device>.+)$`)
+
+	tests := []struct {
+		hostname string
+		want     []string
+	}{
+		{"ITB-1101-CP1", []string{"default", "ITB", "ITB-1101", "ITB-1101-CP1"}},
+		{"localhost", []string{"default", "localhost"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			if got := configDocIDs(tt.hostname); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeHostConfig(t *testing.T) {
+	inherited := HostConfig{
+		Hostname: ".*",
+		Replications: []DatabaseConfig{
+			{Database: "devices", Interval: 300},
+			{Database: "rooms", Interval: 300},
+			{Database: "buildings", Interval: 300},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		rule    HostConfig
+		want    []string
+		devices int
+	}{
+		{
+			name:    "override",
+			rule:    HostConfig{Replications: []DatabaseConfig{{Database: "devices", Interval: 60}}},
+			want:    []string{"devices", "rooms", "buildings"},
+			devices: 60,
+		},
+		{
+			name:    "override adds new databases",
+			rule:    HostConfig{Replications: []DatabaseConfig{{Database: "ui-config", Merge: MergeOverride}}},
+			want:    []string{"devices", "rooms", "buildings", "ui-config"},
+			devices: 300,
+		},
+		{
+			name:    "add keeps inherited databases",
+			rule:    HostConfig{Replications: []DatabaseConfig{{Database: "devices", Interval: 60, Merge: MergeAdd}, {Database: "ui-config", Merge: MergeAdd}}},
+			want:    []string{"devices", "rooms", "buildings", "ui-config"},
+			devices: 300,
+		},
+		{
+			name:    "remove",
+			rule:    HostConfig{Replications: []DatabaseConfig{{Database: "rooms", Merge: MergeRemove}, {Database: "missing", Merge: MergeRemove}}},
+			want:    []string{"devices", "buildings"},
+			devices: 300,
+		},
+		{
+			name:    "replace",
+			rule:    HostConfig{Replace: true, Replications: []DatabaseConfig{{Database: "devices", Interval: 60}}},
+			want:    []string{"devices"},
+			devices: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeHostConfig(inherited, tt.rule)
+			if !reflect.DeepEqual(databases(got), tt.want) {
+				t.Errorf("got databases %v, want %v", databases(got), tt.want)
+			}
+
+			for _, c := range got.Replications {
+				if len(c.Merge) > 0 {
+					t.Errorf("merge strategy %q of %v was kept", c.Merge, c.Database)
+				}
+				if c.Database == "devices" && c.Interval != tt.devices {
+					t.Errorf("got devices interval %v, want %v", c.Interval, tt.devices)
+				}
+			}
+		})
+	}
+
+	if len(inherited.Replications) != 3 || inherited.Replications[1].Database != "rooms" {
+		t.Errorf("the inherited config was changed: %v", databases(inherited))
+	}
+}
+
+const layeredConfig = `
+- _id: default
+  rules:
+  - hostname: ".*"
+    replications:
+    - database: devices
+      interval: 300
+    - database: rooms
+      interval: 300
+- _id: ITB
+  rules:
+  - hostname: "^ITB-1101-"
+    replications:
+    - database: ui-config
+      interval: 600
+  - hostname: ".*"
+    replications:
+    - database: rooms
+      merge: remove
+- _id: ITB-1101
+  rules:
+  - hostname: "^nothing$"
+    replications:
+    - database: devices
+      merge: remove
+- _id: ITB-1101-CP1
+  rules:
+  - hostname: ".*"
+    replications:
+    - database: devices
+      interval: 60
+`
+
+func TestResolveConfig(t *testing.T) {
+	useHostnamePattern(t, `^(?P<building>[^-]+)-(?P<room>[^-]+)-(?P<device>.+)$`)
+	useConfigDocs(t, layeredConfig)
+
+	tests := []struct {
+		hostname string
+		want     []string
+		layers   []ConfigLayer
+		devices  int
+	}{
+		{
+			hostname: "ITB-1101-CP1",
+			want:     []string{"devices", "rooms", "ui-config"},
+			layers: []ConfigLayer{
+				{Document: "default", Rule: ".*"},
+				{Document: "ITB", Rule: "^ITB-1101-"},
+				{Document: "ITB-1101-CP1", Rule: ".*"},
+			},
+			devices: 60,
+		},
+		{
+			hostname: "ITB-1102-CP1",
+			want:     []string{"devices"},
+			layers: []ConfigLayer{
+				{Document: "default", Rule: ".*"},
+				{Document: "ITB", Rule: ".*"},
+			},
+			devices: 300,
+		},
+		{
+			hostname: "JFSB-B100-CP1",
+			want:     []string{"devices", "rooms"},
+			layers:   []ConfigLayer{{Document: "default", Rule: ".*"}},
+			devices:  300,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			resolved, err := ResolveConfig(context.Background(), tt.hostname)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(databases(resolved.Config), tt.want) {
+				t.Errorf("got databases %v, want %v", databases(resolved.Config), tt.want)
+			}
+			if !reflect.DeepEqual(resolved.Layers, tt.layers) {
+				t.Errorf("got layers %+v, want %+v", resolved.Layers, tt.layers)
+			}
+			if resolved.Config.Replications[0].Interval != tt.devices {
+				t.Errorf("got devices interval %v, want %v", resolved.Config.Replications[0].Interval, tt.devices)
+			}
+		})
+	}
+}
+
+func TestResolveConfigMissing(t *testing.T) {
+	useHostnamePattern(t, `^(?P<building>[^-]+)-(?P<room>[^-]+)-(?P<device>.+)$`)
+
+	useConfigDocs(t, `[{"_id": "other", "rules": []}]`)
+	if _, err := ResolveConfig(context.Background(), "ITB-1101-CP1"); err == nil || err.Type != "not_found" {
+		t.Errorf("got %v, want a not_found error without any config documents", err)
+	}
+
+	useConfigDocs(t, `[{"_id": "default", "rules": [{"hostname": "^nothing$"}]}]`)
+	if _, err := ResolveConfig(context.Background(), "ITB-1101-CP1"); err == nil || err.Type != "not_found" {
+		t.Errorf("got %v, want a not_found error without a matching rule", err)
+	}
+}
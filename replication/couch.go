@@ -0,0 +1,26 @@
+package replication
+
+import (
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//localCouch is the couch server replications are scheduled on. The remote servers are in sources.
+var localCouch *couchclient.Client
+
+//translateCouchErr converts an error from the couch client into a nerr, with a type of not_found, conflict,
+//or unauthorized when appropriate
+func translateCouchErr(err error) *nerr.E {
+	e := nerr.Translate(err)
+
+	switch {
+	case couchclient.IsNotFound(err):
+		e.SetType("not_found")
+	case couchclient.IsConflict(err):
+		e.SetType("conflict")
+	case couchclient.IsUnauthorized(err):
+		e.SetType("unauthorized")
+	}
+
+	return e
+}
@@ -0,0 +1,401 @@
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//fakeCouch is an in memory couch server with just enough of the api for the replication package
+type fakeCouch struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	dbs       map[string]map[string]map[string]interface{}
+	seqs      map[string]interface{}
+	scheduler map[string]couchclient.SchedulerDoc
+	tasks     []couchclient.ActiveTask
+	posted    []couchclient.ReplicationDoc
+
+	//conflicts is how many more document writes are answered with a conflict
+	conflicts int
+}
+
+func newFakeCouch(t *testing.T) *fakeCouch {
+	t.Helper()
+
+	f := &fakeCouch{
+		dbs:       make(map[string]map[string]map[string]interface{}),
+		seqs:      make(map[string]interface{}),
+		scheduler: make(map[string]couchclient.SchedulerDoc),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+//useLocalCouch makes f the local couch server for the rest of the test
+func useLocalCouch(t *testing.T, f *fakeCouch) {
+	t.Helper()
+
+	prev := localCouch
+	localCouch = couchclient.New(f.URL, "", "")
+	t.Cleanup(func() {
+		localCouch = prev
+	})
+}
+
+//useRemoteCouch replicates from f for the rest of the test
+func useRemoteCouch(t *testing.T, f *fakeCouch) {
+	t.Helper()
+
+	setSources([]*source{{name: "primary", client: couchclient.New(f.URL, "", "")}}, nil)
+	t.Cleanup(func() {
+		setSources(nil, nil)
+	})
+}
+
+//useSettings changes the settings for the rest of the test
+func useSettings(t *testing.T, change func(s *settings.Settings)) {
+	t.Helper()
+
+	prev := cfg
+	change(&cfg)
+	t.Cleanup(func() {
+		cfg = prev
+	})
+}
+
+func (f *fakeCouch) createDB(db string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dbs[db] == nil {
+		f.dbs[db] = make(map[string]map[string]interface{})
+	}
+}
+
+//put stores a document with the given revision, creating the database if it's missing
+func (f *fakeCouch) put(db, id, rev string) {
+	f.createDB(db)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dbs[db][id] = map[string]interface{}{"_id": id, "_rev": rev}
+}
+
+//setSeq sets the update_seq of db, which is a number for couch 1 and a string for later versions
+func (f *fakeCouch) setSeq(db string, seq interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seqs[db] = seq
+}
+
+func (f *fakeCouch) setState(id, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scheduler[id] = couchclient.SchedulerDoc{DocID: id, State: state}
+}
+
+//conflict answers the next n document writes with a conflict
+func (f *fakeCouch) conflict(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.conflicts = n
+}
+
+func (f *fakeCouch) clearStates() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scheduler = make(map[string]couchclient.SchedulerDoc)
+}
+
+func (f *fakeCouch) setScheduler(doc couchclient.SchedulerDoc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scheduler[doc.DocID] = doc
+}
+
+func (f *fakeCouch) setTasks(tasks ...couchclient.ActiveTask) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tasks = tasks
+}
+
+func (f *fakeCouch) postedDocs() []couchclient.ReplicationDoc {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]couchclient.ReplicationDoc{}, f.posted...)
+}
+
+//docs are the documents in db, sorted by id
+func (f *fakeCouch) docs(db string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.sorted(db, false)
+}
+
+func (f *fakeCouch) sorted(db string, descending bool) []map[string]interface{} {
+	docs := make([]map[string]interface{}, 0, len(f.dbs[db]))
+	for _, doc := range f.dbs[db] {
+		docs = append(docs, doc)
+	}
+
+	sort.Slice(docs, func(i, k int) bool {
+		less := docs[i]["_id"].(string) < docs[k]["_id"].(string)
+		if descending {
+			return !less
+		}
+		return less
+	})
+
+	return docs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // nolint:errcheck
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "not_found", "reason": "missing"})
+}
+
+func (f *fakeCouch) serve(w http.ResponseWriter, r *http.Request) {
+	var parts []string
+	for _, p := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		s, _ := url.PathUnescape(p)
+		parts = append(parts, s)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		writeJSON(w, http.StatusOK, map[string]string{"couchdb": "Welcome"})
+	case parts[0] == "_active_tasks":
+		writeJSON(w, http.StatusOK, append([]couchclient.ActiveTask{}, f.tasks...))
+	case parts[0] == "_scheduler" && parts[1] == "jobs":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": []interface{}{}})
+	case parts[0] == "_scheduler" && len(parts) == 3:
+		docs := []couchclient.SchedulerDoc{}
+		for _, doc := range f.scheduler {
+			docs = append(docs, doc)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"docs": docs})
+	case parts[0] == "_scheduler":
+		doc, ok := f.scheduler[parts[3]]
+		if !ok {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	case len(parts) == 1:
+		f.serveDB(w, r, parts[0], body)
+	case parts[1] == "_all_docs":
+		f.serveAllDocs(w, r, parts[0], body)
+	case parts[1] == "_find":
+		f.serveFind(w, parts[0], body)
+	default:
+		f.serveDoc(w, r, parts[0], parts[1], body)
+	}
+}
+
+func (f *fakeCouch) serveDB(w http.ResponseWriter, r *http.Request, db string, body []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		docs, ok := f.dbs[db]
+		if !ok {
+			notFound(w)
+			return
+		}
+
+		seq, ok := f.seqs[db]
+		if !ok {
+			seq = fmt.Sprintf("%v-fake", len(docs))
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"db_name": db, "doc_count": len(docs), "update_seq": seq})
+	case http.MethodPut:
+		if f.dbs[db] == nil {
+			f.dbs[db] = make(map[string]map[string]interface{})
+		}
+		writeJSON(w, http.StatusCreated, map[string]bool{"ok": true})
+	case http.MethodPost:
+		var doc map[string]interface{}
+		json.Unmarshal(body, &doc) // nolint:errcheck
+
+		id, _ := doc["_id"].(string)
+		if f.write(w, db, id, doc) && db == couchclient.ReplicatorDB {
+			var rdoc couchclient.ReplicationDoc
+			json.Unmarshal(body, &rdoc) // nolint:errcheck
+			f.posted = append(f.posted, rdoc)
+		}
+	}
+}
+
+func (f *fakeCouch) serveDoc(w http.ResponseWriter, r *http.Request, db, id string, body []byte) {
+	docs, ok := f.dbs[db]
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	cur, exists := docs[id]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, cur)
+	case http.MethodPut:
+		var doc map[string]interface{}
+		json.Unmarshal(body, &doc) // nolint:errcheck
+		f.write(w, db, id, doc)
+	case http.MethodDelete:
+		if !exists || cur["_rev"] != r.URL.Query().Get("rev") {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "conflict", "reason": "Document update conflict."})
+			return
+		}
+		delete(docs, id)
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+}
+
+//write stores doc if its revision is the current one, giving it the next revision. It reports whether doc was stored.
+func (f *fakeCouch) write(w http.ResponseWriter, db, id string, doc map[string]interface{}) bool {
+	if f.dbs[db] == nil {
+		f.dbs[db] = make(map[string]map[string]interface{})
+	}
+
+	cur, exists := f.dbs[db][id]
+	rev, _ := doc["_rev"].(string)
+
+	if f.conflicts > 0 || (exists && cur["_rev"] != rev) || (!exists && len(rev) > 0) {
+		if f.conflicts > 0 {
+			f.conflicts--
+		}
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "conflict", "reason": "Document update conflict."})
+		return false
+	}
+
+	doc["_id"] = id
+	doc["_rev"] = fmt.Sprintf("%v-fake", revGeneration(rev)+1)
+	f.dbs[db][id] = doc
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": id, "rev": doc["_rev"]})
+	return true
+}
+
+func (f *fakeCouch) serveAllDocs(w http.ResponseWriter, r *http.Request, db string, body []byte) {
+	if _, ok := f.dbs[db]; !ok {
+		notFound(w)
+		return
+	}
+
+	//with keys, only the revision of each key is returned
+	if r.Method == http.MethodPost {
+		var req struct {
+			Keys []string `json:"keys"`
+		}
+		json.Unmarshal(body, &req) // nolint:errcheck
+
+		rows := []map[string]interface{}{}
+		for _, key := range req.Keys {
+			doc, ok := f.dbs[db][key]
+			if !ok {
+				rows = append(rows, map[string]interface{}{"key": key, "error": "not_found"})
+				continue
+			}
+			rows = append(rows, map[string]interface{}{"id": key, "key": key, "value": map[string]interface{}{"rev": doc["_rev"]}})
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+		return
+	}
+
+	query := r.URL.Query()
+	descending := query.Get("descending") == "true"
+
+	var start, end string
+	json.Unmarshal([]byte(query.Get("startkey")), &start) // nolint:errcheck
+	json.Unmarshal([]byte(query.Get("endkey")), &end)     // nolint:errcheck
+
+	rows := []map[string]interface{}{}
+	for _, doc := range f.sorted(db, descending) {
+		id := doc["_id"].(string)
+		if descending && ((len(start) > 0 && id > start) || (len(end) > 0 && id < end)) {
+			continue
+		}
+		if !descending && ((len(start) > 0 && id < start) || (len(end) > 0 && id > end)) {
+			continue
+		}
+		rows = append(rows, map[string]interface{}{"id": id, "key": id, "doc": doc})
+	}
+
+	rows = page(rows, query.Get("skip"), query.Get("limit"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+}
+
+//serveFind returns every document, ignoring the selector
+func (f *fakeCouch) serveFind(w http.ResponseWriter, db string, body []byte) {
+	if _, ok := f.dbs[db]; !ok {
+		notFound(w)
+		return
+	}
+
+	var req couchclient.FindRequest
+	json.Unmarshal(body, &req) // nolint:errcheck
+
+	docs := []map[string]interface{}{}
+	for _, doc := range f.sorted(db, false) {
+		docs = append(docs, map[string]interface{}{"_id": doc["_id"], "_rev": doc["_rev"]})
+	}
+
+	docs = page(docs, strconv.Itoa(req.Skip), strconv.Itoa(req.Limit))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"docs": docs})
+}
+
+func page(rows []map[string]interface{}, skip, limit string) []map[string]interface{} {
+	if n, _ := strconv.Atoi(skip); n > 0 {
+		if n > len(rows) {
+			n = len(rows)
+		}
+		rows = rows[n:]
+	}
+
+	if n, _ := strconv.Atoi(limit); n > 0 && n < len(rows) {
+		rows = rows[:n]
+	}
+
+	return rows
+}
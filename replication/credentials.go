@@ -0,0 +1,249 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/internal/redact"
+)
+
+//Ways of giving credentials in replication documents
+const (
+	//ReplicationAuthHeaders sends the credentials in an Authorization header, which works with any version of couch
+	ReplicationAuthHeaders = "headers"
+
+	//ReplicationAuthObject uses the auth object, which needs couch 3.2 or later
+	ReplicationAuthObject = "auth"
+
+	//ReplicationAuthAuto uses the auth object if the local couch server supports it, and headers if it doesn't
+	ReplicationAuthAuto = "auto"
+)
+
+//replicationAuth is how credentials are given in replication documents, once auto has been resolved
+var replicationAuth = ReplicationAuthHeaders
+
+//how often credential files are checked for changes
+const credentialCheckInterval = 30 * time.Second
+
+//credentials are the username and password for couch clients, either of which may be read from a file
+type credentials struct {
+	name     string
+	clients  []*couchclient.Client
+	user     string
+	pass     string
+	userFile string
+	passFile string
+
+	modTimes map[string]time.Time
+
+	//redacted is whether the password will be redacted from the logs
+	redacted bool
+}
+
+var localCreds, remoteCreds *credentials
+
+func newCredentials(name string, clients []*couchclient.Client, user, pass, userFile, passFile string) *credentials {
+	return &credentials{
+		name:     name,
+		clients:  clients,
+		user:     user,
+		pass:     pass,
+		userFile: userFile,
+		passFile: passFile,
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+//load reads any credential files that have changed since they were last read, and gives the credentials to the
+//clients. It reports whether anything changed.
+func (c *credentials) load() (bool, *nerr.E) {
+	changed := false
+
+	read := func(file string, dst *string) *nerr.E {
+		if len(file) == 0 {
+			return nil
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nerr.Translate(err).Addf("Couldn't read the %v credentials file %v", c.name, file)
+		}
+
+		if info.ModTime().Equal(c.modTimes[file]) {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nerr.Translate(err).Addf("Couldn't read the %v credentials file %v", c.name, file)
+		}
+
+		c.modTimes[file] = info.ModTime()
+		if val := strings.TrimSpace(string(b)); val != *dst {
+			*dst = val
+			changed = true
+		}
+
+		return nil
+	}
+
+	if err := read(c.userFile, &c.user); err != nil {
+		return false, err
+	}
+
+	if err := read(c.passFile, &c.pass); err != nil {
+		return false, err
+	}
+
+	//usernames are common words like admin, so they're only redacted where they're formatted as credentials
+	c.redacted = redact.AddSecret(c.pass) || len(c.pass) == 0
+	for _, client := range c.clients {
+		client.SetCredentials(c.user, c.pass)
+	}
+
+	return changed, nil
+}
+
+//loadCredentials reads the credentials for the local and remote couch servers
+func loadCredentials() *nerr.E {
+	localCreds = newCredentials("local", []*couchclient.Client{localCouch}, cfg.CouchUser, cfg.CouchPass, cfg.CouchUserFile, cfg.CouchPassFile)
+	remoteCreds = newCredentials("remote", sourceClients(), cfg.RemoteUser, cfg.RemotePass, cfg.RemoteUserFile, cfg.RemotePassFile)
+
+	for _, c := range []*credentials{localCreds, remoteCreds} {
+		if _, err := c.load(); err != nil {
+			return err
+		}
+
+		c.warnUnredacted()
+	}
+
+	return nil
+}
+
+//warnUnredacted warns if the password is too short to be redacted from the logs
+func (c *credentials) warnUnredacted() {
+	if !c.redacted {
+		l.L.Warnf("The %v password is shorter than %v characters, so it won't be redacted from the logs.", c.name, redact.MinSecretLength)
+	}
+}
+
+//watchCredentials reloads the credential files when they change, until ctx is cancelled
+func watchCredentials(ctx context.Context) {
+	t := time.NewTicker(credentialCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		for _, c := range []*credentials{localCreds, remoteCreds} {
+			changed, err := c.load()
+			if err != nil {
+				l.L.Warnf("Keeping the old %v credentials: %v", c.name, err.Error())
+				continue
+			}
+
+			if changed {
+				c.warnUnredacted()
+				l.L.Infof("Reloaded the %v credentials. Continuous replications will be replaced with the new credentials now, and the rest the next time they're scheduled.", c.name)
+				credentialsReloaded()
+			}
+		}
+	}
+}
+
+var creds = struct {
+	sync.Mutex
+
+	//changed is when the credentials were last reloaded
+	changed time.Time
+
+	//reloaded is closed when the credentials are reloaded
+	reloaded chan struct{}
+}{reloaded: make(chan struct{})}
+
+func credentialsReloaded() {
+	creds.Lock()
+	creds.changed = time.Now()
+	close(creds.reloaded)
+	creds.reloaded = make(chan struct{})
+	creds.Unlock()
+
+	forgetPosted()
+}
+
+func credentialsChangedAt() time.Time {
+	creds.Lock()
+	defer creds.Unlock()
+
+	return creds.changed
+}
+
+//credentialsChanged returns a channel that's closed the next time the credentials are reloaded
+func credentialsChanged() <-chan struct{} {
+	creds.Lock()
+	defer creds.Unlock()
+
+	return creds.reloaded
+}
+
+//endpoint builds the replication endpoint for db on the server client talks to, with the client's credentials
+func endpoint(client *couchclient.Client, db string) couchclient.Endpoint {
+	e := couchclient.Endpoint{
+		URL: fmt.Sprintf("%v/%v", client.Addr(), db),
+	}
+
+	user, pass := client.Credentials()
+	if len(user) == 0 && len(pass) == 0 {
+		return e
+	}
+
+	if replicationAuth == ReplicationAuthObject {
+		e.Auth = &couchclient.EndpointAuth{
+			Basic: &couchclient.BasicAuth{
+				Username: user,
+				Password: pass,
+			},
+		}
+		return e
+	}
+
+	e.Headers = map[string]string{
+		"Authorization": couchclient.BasicAuthHeader(user, pass),
+	}
+
+	return e
+}
+
+//resolveReplicationAuth decides how credentials are given in replication documents, checking the local couch
+//server's version if it's set to auto
+func resolveReplicationAuth(ctx context.Context) {
+	replicationAuth = cfg.ReplicationAuth
+	if replicationAuth == ReplicationAuthAuto {
+		info, err := localCouch.Info(ctx)
+		switch {
+		case err != nil:
+			l.L.Warnf("Unable to get the local couch version, credentials will be given in headers: %v", err.Error())
+			replicationAuth = ReplicationAuthHeaders
+		case info.AtLeast(3, 2):
+			replicationAuth = ReplicationAuthObject
+		default:
+			replicationAuth = ReplicationAuthHeaders
+		}
+	}
+
+	if replicationAuth == ReplicationAuthHeaders {
+		l.L.Warn("Credentials are given in replication documents as Authorization headers, which anyone who can read _replicator can decode. Use couch 3.2 or later so the auth object can be used instead.")
+	}
+}
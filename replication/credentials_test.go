@@ -0,0 +1,79 @@
+package replication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/internal/redact"
+)
+
+func writeCredential(t *testing.T, path, val string, mod time.Time) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(val+"\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCredentialsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	userFile, passFile := filepath.Join(dir, "user"), filepath.Join(dir, "pass")
+	start := time.Now().Add(-time.Hour)
+	writeCredential(t, userFile, "replicator-old", start)
+	writeCredential(t, passFile, "password-old", start)
+
+	client := couchclient.New("http://couch:5984", "", "")
+	c := newCredentials("remote", []*couchclient.Client{client}, "", "", userFile, passFile)
+
+	if changed, err := c.load(); err != nil || !changed {
+		t.Fatalf("got changed %v and error %v loading the files, want them read", changed, err)
+	}
+	if changed, err := c.load(); err != nil || changed {
+		t.Errorf("got changed %v and error %v loading files that didn't change", changed, err)
+	}
+
+	writeCredential(t, userFile, "replicator-new", start.Add(time.Minute))
+	writeCredential(t, passFile, "password-new", start.Add(time.Minute))
+
+	if changed, err := c.load(); err != nil || !changed {
+		t.Fatalf("got changed %v and error %v after the files changed", changed, err)
+	}
+
+	if user, pass := client.Credentials(); user != "replicator-new" || pass != "password-new" {
+		t.Errorf("got credentials %v:%v, want the new ones given to the client", user, pass)
+	}
+
+	logged := redact.String("replicating as replicator-new with password-new")
+	if want := "replicating as replicator-new with " + redact.Mask; logged != want {
+		t.Errorf("got %q, want only the password redacted", logged)
+	}
+}
+
+func TestCredentialsReloadedSignalsJobs(t *testing.T) {
+	reloaded := credentialsChanged()
+	if changedSince(reloaded) {
+		t.Fatalf("got a reload before the credentials were reloaded")
+	}
+
+	credentialsReloaded()
+	if !changedSince(reloaded) {
+		t.Errorf("the credentials were reloaded without telling the jobs")
+	}
+	if changedSince(credentialsChanged()) {
+		t.Errorf("got a reload from the next channel before the credentials were reloaded again")
+	}
+}
@@ -0,0 +1,169 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/internal/events"
+)
+
+//how many events are held while the sinks catch up
+const eventQueueSize = 256
+
+var (
+	eventStream    = events.NewStream()
+	eventPublisher = events.NewPublisher(0)
+)
+
+//initEvents sets up the sinks events are sent to. The websocket stream is always one of them.
+func initEvents() *nerr.E {
+	eventStream.AllowOrigins(cfg.EventOrigins)
+	sinks := []events.Sink{eventStream}
+
+	for _, url := range cfg.EventURLs {
+		sinks = append(sinks, events.NewHTTPSink(url))
+	}
+
+	if len(cfg.EventFile) > 0 {
+		sink, err := events.NewFileSink(cfg.EventFile)
+		if err != nil {
+			return nerr.Translate(err).Addf("Couldn't set up the event file %v", cfg.EventFile).SetType("invalid_args")
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	eventPublisher = events.NewPublisher(eventQueueSize, sinks...)
+	return nil
+}
+
+//EventStream is the websocket stream of events
+func EventStream() *events.Stream {
+	return eventStream
+}
+
+//publish fills in where e happened and sends it to every sink
+func publish(e events.Event) {
+	e.Hostname = cfg.Hostname
+	if h, err := ParseHostname(cfg.Hostname); err == nil {
+		e.Building = h.Building
+		e.Room = h.Room
+	}
+
+	eventPublisher.Publish(e)
+}
+
+//replStates are the last known state of each replication
+var replStates = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+//observeState records the state of a replication, publishing an event and reporting true if it changed
+func observeState(db, replID, state, errMsg string) bool {
+	replStates.Lock()
+	old, ok := replStates.m[replID]
+	replStates.m[replID] = state
+	replStates.Unlock()
+
+	if ok && old == state {
+		return false
+	}
+
+	l.L.Debugf("Replication %v is now %v", replID, state)
+	publish(events.Event{
+		Type:        events.TypeTransition,
+		Database:    db,
+		Replication: replID,
+		OldState:    old,
+		NewState:    state,
+		Error:       errMsg,
+	})
+
+	return true
+}
+
+//publishScheduleError publishes that a replication couldn't be scheduled
+func publishScheduleError(db, replID string, err *nerr.E) {
+	publish(events.Event{
+		Type:        events.TypeScheduleError,
+		Database:    db,
+		Replication: replID,
+		Error:       err.Error(),
+	})
+}
+
+//publishConfigReload publishes that a changed config was applied
+func publishConfigReload(config HostConfig) {
+	publish(events.Event{
+		Type:    events.TypeConfigReload,
+		Message: fmt.Sprintf("%v databases are configured", len(config.Replications)),
+	})
+}
+
+//schedulerError is the error of a replication that isn't doing well. Older versions of couch only give a message.
+func schedulerError(doc couchclient.SchedulerDoc) string {
+	if msg, ok := doc.Info.(string); ok {
+		return msg
+	}
+
+	info, _ := couchclient.ParseReplicationInfo(doc.Info)
+	return info.Error
+}
+
+//checkTransitions looks for replications that have changed state since they were last checked, and saves their
+//current run to the history when they have
+func checkTransitions(ctx context.Context) {
+	docs, err := localCouch.SchedulerDocs(ctx)
+	if err != nil {
+		l.L.Debugf("Couldn't check replications for state changes: %v", err)
+		return
+	}
+
+	for _, config := range trackedConfigs() {
+		directions, err := config.Directions()
+		if err != nil {
+			continue
+		}
+
+		for _, direction := range directions {
+			//a missing doc is being replaced, which is published when it's posted
+			doc, ok := docs[ReplicationID(config.Database, direction)]
+			if !ok {
+				continue
+			}
+
+			if doc.State == "completed" {
+				markCaughtUp(doc.DocID, doc.LastUpdated)
+			}
+
+			if observeState(config.Database, doc.DocID, doc.State, schedulerError(doc)) {
+				recordRun(ctx, config, direction, doc, false)
+			}
+		}
+	}
+}
+
+//watchEvents sends events, and checks replications for state changes on the event poll interval, until ctx is
+//cancelled
+func watchEvents(ctx context.Context) {
+	go eventPublisher.Run(ctx)
+
+	t := time.NewTicker(time.Duration(cfg.EventPollInterval) * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		checkTransitions(ctx)
+	}
+}
@@ -0,0 +1,125 @@
+package replication
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/internal/events"
+)
+
+//eventSink keeps every event it's sent
+type eventSink struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (s *eventSink) Name() string {
+	return "test"
+}
+
+func (s *eventSink) Send(ctx context.Context, e events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+	return nil
+}
+
+//useEventSink publishes events to a new sink for the rest of the test
+func useEventSink(t *testing.T) *eventSink {
+	t.Helper()
+
+	sink := &eventSink{}
+	prev := eventPublisher
+	eventPublisher = events.NewPublisher(16, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		eventPublisher.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		eventPublisher = prev
+	})
+
+	return sink
+}
+
+//waitFor waits for s to have been sent n events
+func (s *eventSink) waitFor(t *testing.T, n int) []events.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		got := append([]events.Event{}, s.events...)
+		s.mu.Unlock()
+
+		if len(got) >= n {
+			return got
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v events, want %v", len(got), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestObserveState(t *testing.T) {
+	sink := useEventSink(t)
+
+	replID := "auto_observe_test"
+	replStates.Lock()
+	delete(replStates.m, replID)
+	replStates.Unlock()
+
+	steps := []struct {
+		state   string
+		errMsg  string
+		changed bool
+	}{
+		{state: "added", changed: true},
+		{state: "added"},
+		{state: "running", changed: true},
+		{state: "crashing", errMsg: "source is down", changed: true},
+		{state: "crashing", errMsg: "source is down"},
+		{state: "running", changed: true},
+	}
+
+	published := 0
+	for i, step := range steps {
+		if changed := observeState("observe", replID, step.state, step.errMsg); changed != step.changed {
+			t.Errorf("got changed %v for step %v (%v), want %v", changed, i, step.state, step.changed)
+		}
+		if step.changed {
+			published++
+		}
+	}
+
+	got := sink.waitFor(t, published)
+	want := []events.Event{
+		{OldState: "", NewState: "added"},
+		{OldState: "added", NewState: "running"},
+		{OldState: "running", NewState: "crashing", Error: "source is down"},
+		{OldState: "crashing", NewState: "running"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v events, want %v", len(got), len(want))
+	}
+
+	for i := range want {
+		e := got[i]
+		if e.Type != events.TypeTransition || e.Replication != replID || e.Database != "observe" ||
+			e.OldState != want[i].OldState || e.NewState != want[i].NewState || e.Error != want[i].Error {
+			t.Errorf("got event %+v, want %+v", e, want[i])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//a job that's this far past its next run is assumed to be stuck
+const livenessGrace = 5 * time.Minute
+
+//Health is the result of a liveness or readiness check
+type Health struct {
+	OK     bool          `json:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+//HealthCheck is a single part of a liveness or readiness check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+func (h *Health) add(name string, ok bool, format string, a ...interface{}) {
+	h.Checks = append(h.Checks, HealthCheck{
+		Name:    name,
+		OK:      ok,
+		Message: fmt.Sprintf(format, a...),
+	})
+
+	if !ok {
+		h.OK = false
+	}
+}
+
+var scheduler = struct {
+	sync.Mutex
+	started bool
+	running bool
+}{}
+
+func schedulerRunning(running bool) {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	scheduler.started = true
+	scheduler.running = running
+}
+
+//schedulerState reports whether the replication jobs have been started, and whether they're still running
+func schedulerState() (started, running bool) {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+
+	return scheduler.started, scheduler.running
+}
+
+//Liveness reports whether the scheduler is still running its jobs. A service that's still starting up is alive.
+func Liveness() Health {
+	h := Health{OK: true}
+
+	started, running := schedulerState()
+	switch {
+	case cfg.StopReplication:
+		h.add("scheduler", true, "replication is stopped by the settings")
+		return h
+	case !started:
+		h.add("scheduler", true, "starting")
+		return h
+	case !running:
+		h.add("scheduler", false, "the scheduler has stopped")
+		return h
+	}
+
+	jobs.RLock()
+	_, ok := jobs.m[REPL_CONFIG_DB]
+	list := make([]*job, 0, len(jobs.m))
+	for _, j := range jobs.m {
+		list = append(list, j)
+	}
+	jobs.RUnlock()
+
+	if !ok && replicatingConfig() {
+		h.add("scheduler", false, "the %v job isn't running", REPL_CONFIG_DB)
+	} else {
+		h.add("scheduler", true, "running %v jobs", len(list))
+	}
+
+	//every job has a next run unless it's a continuous replication that's waiting for its config to change
+	var overdue []string
+	now := time.Now()
+	for _, j := range list {
+		status := j.status()
+		if status.NextRun != nil && now.Sub(*status.NextRun) > livenessGrace {
+			overdue = append(overdue, fmt.Sprintf("%v (due %v)", status.Database, status.NextRun.Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(overdue)
+
+	if len(overdue) > 0 {
+		h.add("jobs", false, "jobs haven't run when they were due: %v", strings.Join(overdue, ", "))
+	} else {
+		h.add("jobs", true, "")
+	}
+
+	return h
+}
+
+//Readiness reports whether the local and remote couch servers can be reached, the config has been replicated, and
+//every database has been replicated at least once
+func Readiness(ctx context.Context) Health {
+	h := Health{OK: true}
+
+	if err := localCouch.Ping(ctx); err != nil && !couchclient.IsUnauthorized(err) {
+		h.add("local_couch", false, "unreachable: %v", err)
+	} else {
+		h.add("local_couch", true, "")
+	}
+
+	configured, remote := false, false
+	for _, s := range GetSources() {
+		if s.Alias {
+			continue
+		}
+		configured = true
+
+		//sources start out healthy until they're first checked
+		if s.Healthy && s.LastCheck != nil {
+			remote = true
+			h.add("remote_couch", true, "replicating from %v", s.Name)
+			break
+		}
+	}
+
+	switch {
+	case remote:
+	case !configured && !replicatingConfig():
+		//nothing needs a remote server until the config has a replication, which will wait on it below
+		h.add("remote_couch", true, "skipped, no remote couch server is configured")
+	default:
+		h.add("remote_couch", false, "no remote couch server can be reached")
+	}
+
+	if cfg.StopReplication {
+		h.add("replications", true, "replication is stopped by the settings")
+		return h
+	}
+
+	//a replication that hasn't been seen caught up yet may have caught up since anything last looked
+	var tasks *replicationTasks
+	replicated := func(config DatabaseConfig, direction string) bool {
+		replID := ReplicationID(config.Database, direction)
+		if !lastCaughtUp(replID).IsZero() {
+			return true
+		}
+
+		if tasks == nil {
+			t, _ := getReplicationTasks(ctx)
+			tasks = &t
+		}
+		legProgress(ctx, config, direction, *tasks)
+
+		return !lastCaughtUp(replID).IsZero()
+	}
+
+	switch {
+	case !replicatingConfig():
+		h.add("config", true, "read from %v", cfg.ConfigSource)
+	case !replicated(DatabaseConfig{Database: REPL_CONFIG_DB}, DirectionPull):
+		h.add("config", false, "%v hasn't been replicated yet", REPL_CONFIG_DB)
+	default:
+		h.add("config", true, "")
+	}
+
+	if _, running := schedulerState(); !running {
+		h.add("replications", false, "the replication jobs haven't started")
+		return h
+	}
+
+	var waiting []string
+	for _, config := range trackedConfigs() {
+		if config.Database == REPL_CONFIG_DB {
+			continue
+		}
+
+		directions, err := config.Directions()
+		if err != nil {
+			waiting = append(waiting, config.Database)
+			continue
+		}
+
+		for _, direction := range directions {
+			if !replicated(config, direction) {
+				waiting = append(waiting, ReplicationID(config.Database, direction))
+			}
+		}
+	}
+
+	if len(waiting) > 0 {
+		h.add("replications", false, "not replicated yet: %v", strings.Join(waiting, ", "))
+	} else {
+		h.add("replications", true, "")
+	}
+
+	return h
+}
@@ -0,0 +1,96 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func healthCheck(h Health, name string) (HealthCheck, bool) {
+	for _, c := range h.Checks {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return HealthCheck{}, false
+}
+
+func TestReadinessRemoteCouch(t *testing.T) {
+	useLocalCouch(t, newFakeCouch(t))
+
+	down := newFakeSource(t)
+	down.set(http.StatusServiceUnavailable)
+
+	tests := []struct {
+		name    string
+		sources []*fakeSource
+		couch   bool
+		ok      bool
+	}{
+		{name: "no sources with a static config", ok: true},
+		{name: "no sources with the config from couch", couch: true},
+		{name: "unreachable source with a static config", sources: []*fakeSource{down}},
+		{name: "reachable source", sources: []*fakeSource{newFakeSource(t)}, couch: true, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSources(t, []string{"primary"}, tt.sources...)
+			checkSources(context.Background(), 1)
+
+			if tt.couch {
+				prev := configProvider
+				SetConfigProvider(CouchConfigProvider{})
+				t.Cleanup(func() {
+					SetConfigProvider(prev)
+				})
+			} else {
+				useConfigDocs(t, `[{"_id": "default", "rules": []}]`)
+			}
+
+			check, ok := healthCheck(Readiness(context.Background()), "remote_couch")
+			if !ok || check.OK != tt.ok {
+				t.Errorf("got %+v, want ok to be %v", check, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLivenessConfigJob(t *testing.T) {
+	schedulerRunning(true)
+	t.Cleanup(func() {
+		scheduler.Lock()
+		scheduler.started, scheduler.running = false, false
+		scheduler.Unlock()
+	})
+
+	tests := []struct {
+		name  string
+		couch bool
+		ok    bool
+	}{
+		{name: "static config", ok: true},
+		{name: "config from couch", couch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.couch {
+				prev := configProvider
+				SetConfigProvider(CouchConfigProvider{})
+				t.Cleanup(func() {
+					SetConfigProvider(prev)
+				})
+			} else {
+				useConfigDocs(t, `[{"_id": "default", "rules": []}]`)
+			}
+
+			//there's no job for the config database unless it's replicated from couch
+			check, ok := healthCheck(Liveness(), "scheduler")
+			if !ok || check.OK != tt.ok {
+				t.Errorf("got %+v, want ok to be %v", check, tt.ok)
+			}
+		})
+	}
+}
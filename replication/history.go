@@ -0,0 +1,179 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//HistoryEntry is one run of a replication, kept in the local history database after its replication document is gone
+type HistoryEntry struct {
+	ID               string     `json:"_id"`
+	Rev              string     `json:"_rev,omitempty"`
+	Database         string     `json:"database"`
+	Replication      string     `json:"replication"`
+	Direction        string     `json:"direction"`
+	Source           string     `json:"source,omitempty"`
+	Start            time.Time  `json:"start"`
+	End              *time.Time `json:"end,omitempty"`
+	State            string     `json:"state"`
+	DocsRead         int        `json:"docs_read"`
+	DocsWritten      int        `json:"docs_written"`
+	DocWriteFailures int        `json:"doc_write_failures"`
+	Error            string     `json:"error,omitempty"`
+}
+
+//StateReplaced is the state of a run that was replaced before it finished
+const StateReplaced = "replaced"
+
+//ids are the database, then the start of the run, so they sort by when they started
+const historyTimeFormat = "20060102T150405.000000000Z"
+
+func historyPrefix(db string) string {
+	return db + ":"
+}
+
+func historyID(db, direction string, start time.Time) string {
+	return fmt.Sprintf("%v%v:%v", historyPrefix(db), start.UTC().Format(historyTimeFormat), direction)
+}
+
+//finishedState reports whether a replication in state has stopped running on its own
+func finishedState(state string) bool {
+	return state == "completed" || state == "failed"
+}
+
+//recordRun saves the run of a replication described by doc to the history, updating it if it's already been saved.
+//If replaced is set the run is being replaced, so it ends now if it hasn't already.
+func recordRun(ctx context.Context, config DatabaseConfig, direction string, doc couchclient.SchedulerDoc, replaced bool) {
+	if cfg.HistoryLimit <= 0 {
+		return
+	}
+
+	start := doc.StartTime
+	if start.IsZero() {
+		start = doc.LastUpdated
+	}
+	if start.IsZero() {
+		return
+	}
+
+	entry := HistoryEntry{
+		ID:          historyID(config.Database, direction, start),
+		Database:    config.Database,
+		Replication: doc.DocID,
+		Direction:   direction,
+		Source:      postedSource(doc.DocID),
+		Start:       start,
+		State:       doc.State,
+		Error:       schedulerError(doc),
+	}
+
+	if info, ok := couchclient.ParseReplicationInfo(doc.Info); ok {
+		entry.DocsRead = info.DocsRead
+		entry.DocsWritten = info.DocsWritten
+		entry.DocWriteFailures = info.DocWriteFailures
+	}
+
+	switch {
+	case finishedState(doc.State):
+		end := doc.LastUpdated
+		entry.End = &end
+	case replaced:
+		end := time.Now()
+		entry.End = &end
+		entry.State = StateReplaced
+	}
+
+	if err := saveHistory(ctx, entry); err != nil {
+		l.L.Warnf("Couldn't save the history of %v: %v", doc.DocID, err.Error())
+		return
+	}
+
+	if err := pruneHistory(ctx, config.Database); err != nil {
+		l.L.Warnf("Couldn't prune the history of %v: %v", config.Database, err.Error())
+	}
+}
+
+//saveHistory saves entry, updating it if it's already been saved. A run can be saved by the scheduler and the
+//transition checks at the same time, so a conflict is tried again once with the latest revision.
+func saveHistory(ctx context.Context, entry HistoryEntry) *nerr.E {
+	for try := 0; ; try++ {
+		var cur HistoryEntry
+		entry.Rev = ""
+
+		err := localCouch.GetDocument(ctx, REPL_HISTORY_DB, entry.ID, &cur)
+		switch {
+		case err == nil:
+			entry.Rev = cur.Rev
+
+			//a replaced run that was seen finishing keeps how it finished
+			if entry.State == StateReplaced && finishedState(cur.State) {
+				return nil
+			}
+		case !couchclient.IsNotFound(err):
+			return translateCouchErr(err).Addf("Couldn't get history entry %v", entry.ID)
+		}
+
+		_, err = localCouch.PutDocument(ctx, REPL_HISTORY_DB, entry.ID, entry)
+		switch {
+		case err == nil:
+			return nil
+		case couchclient.IsConflict(err) && try == 0:
+			l.L.Debugf("History entry %v was saved by something else, trying again", entry.ID)
+		default:
+			return translateCouchErr(err).Addf("Couldn't save history entry %v", entry.ID)
+		}
+	}
+}
+
+//pruneHistory deletes all but the newest runs of db
+func pruneHistory(ctx context.Context, db string) *nerr.E {
+	var old []couchclient.DocumentRev
+	err := localCouch.AllDocs(ctx, REPL_HISTORY_DB, couchclient.AllDocsOptions{
+		StartKey:   historyPrefix(db) + "\ufff0",
+		EndKey:     historyPrefix(db),
+		Descending: true,
+		Skip:       cfg.HistoryLimit,
+	}, &old)
+	if err != nil {
+		return translateCouchErr(err).Addf("Couldn't list the history of %v", db)
+	}
+
+	for _, doc := range old {
+		if err := localCouch.DeleteDocument(ctx, REPL_HISTORY_DB, doc.ID, doc.Rev); err != nil {
+			return translateCouchErr(err).Addf("Couldn't delete history entry %v", doc.ID)
+		}
+	}
+
+	return nil
+}
+
+//recordReplaced saves the run of a replication that's about to be replaced
+func recordReplaced(ctx context.Context, config DatabaseConfig, direction string) {
+	state, err := getReplicationState(ctx, ReplicationID(config.Database, direction))
+	if err != nil || state.State == "not_started" {
+		return
+	}
+
+	recordRun(ctx, config, direction, state, true)
+}
+
+//GetHistory returns the newest runs of the replications of db, newest first
+func GetHistory(ctx context.Context, db string, limit int) ([]HistoryEntry, *nerr.E) {
+	history := []HistoryEntry{}
+	err := localCouch.AllDocs(ctx, REPL_HISTORY_DB, couchclient.AllDocsOptions{
+		StartKey:   historyPrefix(db) + "\ufff0",
+		EndKey:     historyPrefix(db),
+		Descending: true,
+		Limit:      limit,
+	}, &history)
+	if err != nil {
+		return nil, translateCouchErr(err).Addf("Couldn't get the history of %v", db)
+	}
+
+	return history, nil
+}
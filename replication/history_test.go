@@ -0,0 +1,116 @@
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//useHistory keeps up to limit runs of each database in a fresh history database for the rest of the test
+func useHistory(t *testing.T, limit int) *fakeCouch {
+	t.Helper()
+
+	couch := newFakeCouch(t)
+	couch.createDB(REPL_HISTORY_DB)
+	useLocalCouch(t, couch)
+	useSettings(t, func(s *settings.Settings) {
+		s.HistoryLimit = limit
+	})
+
+	return couch
+}
+
+func TestSaveHistoryConflict(t *testing.T) {
+	couch := useHistory(t, 10)
+	ctx := context.Background()
+
+	entry := HistoryEntry{ID: historyID("devices", DirectionPull, time.Now()), Database: "devices", State: "running"}
+
+	couch.conflict(1)
+	if err := saveHistory(ctx, entry); err != nil {
+		t.Fatalf("got %v after one conflict, want it saved on the second try", err)
+	}
+
+	entry.State = "completed"
+	couch.conflict(2)
+	if err := saveHistory(ctx, entry); err == nil {
+		t.Errorf("got no error after conflicting twice")
+	}
+
+	history, err := GetHistory(ctx, "devices", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 1 || history[0].State != "running" {
+		t.Errorf("got %+v, want the entry from the first save", history)
+	}
+}
+
+func TestHistoryReplacedAndFinished(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	running := couchclient.SchedulerDoc{DocID: "auto_devices", State: "running", StartTime: start}
+	completed := couchclient.SchedulerDoc{DocID: "auto_devices", State: "completed", StartTime: start, LastUpdated: start.Add(time.Minute)}
+
+	tests := []struct {
+		name  string
+		order []bool
+		docs  []couchclient.SchedulerDoc
+		want  string
+	}{
+		{name: "replaced while running", order: []bool{false, true}, docs: []couchclient.SchedulerDoc{running, running}, want: StateReplaced},
+		{name: "replaced after finishing", order: []bool{false, true}, docs: []couchclient.SchedulerDoc{completed, running}, want: "completed"},
+		{name: "seen finishing after being replaced", order: []bool{true, false}, docs: []couchclient.SchedulerDoc{running, completed}, want: "completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useHistory(t, 10)
+			ctx := context.Background()
+			config := DatabaseConfig{Database: "devices"}
+
+			for i, replaced := range tt.order {
+				recordRun(ctx, config, DirectionPull, tt.docs[i], replaced)
+			}
+
+			history, err := GetHistory(ctx, "devices", 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(history) != 1 || history[0].State != tt.want || history[0].End == nil {
+				t.Errorf("got %+v, want one run that ended %v", history, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	couch := useHistory(t, 2)
+	ctx := context.Background()
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		doc := couchclient.SchedulerDoc{DocID: "auto_devices", State: "completed", StartTime: start.Add(time.Duration(i) * time.Minute)}
+		doc.LastUpdated = doc.StartTime.Add(time.Second)
+		recordRun(ctx, DatabaseConfig{Database: "devices"}, DirectionPull, doc, false)
+	}
+
+	//other databases keep their own history
+	couch.put(REPL_HISTORY_DB, historyID("rooms", DirectionPull, start), "1-x")
+
+	history, err := GetHistory(ctx, "devices", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(history) != 2 || !history[0].Start.Equal(start.Add(3*time.Minute)) || !history[1].Start.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("got %+v, want the newest 2 runs, newest first", history)
+	}
+
+	if docs := couch.docs(REPL_HISTORY_DB); len(docs) != 3 {
+		t.Errorf("got %v history entries, want 2 for devices and 1 for rooms", len(docs))
+	}
+}
@@ -0,0 +1,113 @@
+package replication
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//Hostname is a hostname broken up into the pieces used to find its config and build its selectors
+type Hostname struct {
+	Hostname string `json:"hostname"`
+	Building string `json:"building"`
+	Room     string `json:"room,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+//HostnameParser breaks a hostname up into its building, room and device
+type HostnameParser interface {
+	Parse(hostname string) (Hostname, *nerr.E)
+}
+
+//RegexHostnameParser parses hostnames with a regex that has building, room and device named groups. Only the
+//building group is required.
+type RegexHostnameParser struct {
+	re *regexp.Regexp
+}
+
+//NewRegexHostnameParser builds a parser from pattern
+func NewRegexHostnameParser(pattern string) (*RegexHostnameParser, *nerr.E) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nerr.Translate(err).Addf("Invalid hostname pattern %q", pattern).SetType("invalid_args")
+	}
+
+	if re.SubexpIndex("building") < 0 {
+		return nil, nerr.Create(fmt.Sprintf("Hostname pattern %q has no building group", pattern), "invalid_args")
+	}
+
+	return &RegexHostnameParser{re: re}, nil
+}
+
+//Parse implements HostnameParser
+func (p *RegexHostnameParser) Parse(hostname string) (Hostname, *nerr.E) {
+	toReturn := Hostname{Hostname: hostname}
+
+	match := p.re.FindStringSubmatch(hostname)
+	if match == nil {
+		return toReturn, nerr.Create(fmt.Sprintf("Hostname %q doesn't match the hostname pattern %q", hostname, p.re.String()), "invalid_args")
+	}
+
+	group := func(name string) string {
+		if i := p.re.SubexpIndex(name); i >= 0 {
+			return match[i]
+		}
+		return ""
+	}
+
+	toReturn.Building = group("building")
+	toReturn.Room = group("room")
+	toReturn.Device = group("device")
+
+	if len(toReturn.Building) == 0 {
+		return toReturn, nerr.Create(fmt.Sprintf("Couldn't find a building in hostname %q", hostname), "invalid_args")
+	}
+
+	return toReturn, nil
+}
+
+//hostnameParser starts out using the default pattern, and is replaced when the package is configured
+var hostnameParser = struct {
+	sync.RWMutex
+	p HostnameParser
+}{p: defaultHostnameParser()}
+
+func defaultHostnameParser() HostnameParser {
+	p, err := NewRegexHostnameParser(settings.DefaultHostnamePattern)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return p
+}
+
+//SetHostnameParser replaces the parser used for every hostname
+func SetHostnameParser(p HostnameParser) {
+	hostnameParser.Lock()
+	defer hostnameParser.Unlock()
+
+	hostnameParser.p = p
+}
+
+//initHostnameParser sets up the parser from the hostname pattern setting
+func initHostnameParser() *nerr.E {
+	p, err := NewRegexHostnameParser(cfg.HostnamePattern)
+	if err != nil {
+		return err
+	}
+
+	SetHostnameParser(p)
+	return nil
+}
+
+//ParseHostname breaks up hostname with the configured parser
+func ParseHostname(hostname string) (Hostname, *nerr.E) {
+	hostnameParser.RLock()
+	p := hostnameParser.p
+	hostnameParser.RUnlock()
+
+	return p.Parse(hostname)
+}
@@ -0,0 +1,109 @@
+package replication
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+func TestParseHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     Hostname
+		invalid  bool
+	}{
+		{hostname: "ITB-1101-CP1", want: Hostname{Building: "ITB", Room: "1101", Device: "CP1"}},
+		{hostname: "ITB-1101-CP1-DEV", want: Hostname{Building: "ITB", Room: "1101", Device: "CP1-DEV"}},
+		{hostname: "ITB-1101", want: Hostname{Building: "ITB", Room: "1101"}},
+		{hostname: "ITB", invalid: true},
+		{hostname: "-1101-CP1", invalid: true},
+		{hostname: "", invalid: true},
+	}
+
+	p, err := NewRegexHostnameParser(settings.DefaultHostnamePattern)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			got, err := p.Parse(tt.hostname)
+			if tt.invalid {
+				if err == nil || err.Type != "invalid_args" {
+					t.Errorf("got %+v, %v, want an invalid_args error", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tt.want.Hostname = tt.hostname
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostnamePatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		invalid bool
+	}{
+		{name: "building only", pattern: `^(?P<building>[A-Z]+)`},
+		{name: "no building", pattern: `^(?P<room>[^-]+)-(?P<device>.+)$`, invalid: true},
+		{name: "not a regex", pattern: `^(?P<building>[^-]+`, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegexHostnameParser(tt.pattern)
+			if tt.invalid != (err != nil) {
+				t.Errorf("got error %v, want an error: %v", err, tt.invalid)
+			}
+		})
+	}
+
+	p, err := NewRegexHostnameParser(`^(?P<building>[A-Z]+)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := p.Parse("JFSB1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Building != "JFSB" || len(got.Room) > 0 || len(got.Device) > 0 {
+		t.Errorf("got %+v, want only the building", got)
+	}
+}
+
+//the parser can be replaced while hostnames are being parsed, which the race detector checks
+func TestParseHostnameWhileReplacing(t *testing.T) {
+	useHostnamePattern(t, settings.DefaultHostnamePattern)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			if _, err := ParseHostname("ITB-1101-CP1"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if err := initHostnameParser(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -1,64 +1,64 @@
 package replication
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
 	"time"
 
-	"github.com/byuoitav/common/db/couch"
 	l "github.com/byuoitav/common/log"
 	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
 )
 
 var DefaultReplConfig DatabaseConfig
 
-func Init() {
-	l.SetLevel("debug") // nolint:errcheck
-
-	addr := os.Getenv("COUCH_ADDR")
-	if len(addr) < 1 {
-		l.L.Fatal("No COUCH_ADDR specified")
+//Init waits for the local couch server to come up and makes sure the meta databases exist, retrying with backoff
+//until it can. The service is marked as degraded while it waits. It returns early if ctx is cancelled.
+func Init(ctx context.Context) *nerr.E {
+	if _, err := ParseHostname(cfg.Hostname); err != nil {
+		l.L.Warnf("%v. Only the default and host config documents will be used.", err.Error())
 	}
 
-	if len(os.Getenv("COUCH_REPL_ADDR")) < 1 || len(os.Getenv("COUCH_REPL_USER")) < 1 || len(os.Getenv("COUCH_REPL_PASS")) < 1 {
-		l.L.Fatal("Remote environment variables are not declared.")
+	if activeSource() == nil {
+		l.L.Warn("No remote couch server is configured, replications will fail until there is.")
 	}
 
-	l.L.Infof("Checking to see if couch server is up at %v", addr)
+	go watchCredentials(ctx)
+	go watchEvents(ctx)
+
+	l.L.Infof("Checking to see if couch server is up at %v", cfg.CouchAddr)
 
-	// wait until we can reach remote couch server
+	// wait until the local couch server is running, nothing works without it
+	b := newStartupBackoff()
 	for {
-		resp, err := http.Get(os.Getenv("COUCH_REPL_ADDR"))
-		if err != nil {
-			l.L.Info("Waiting for remote couch to start...")
-			l.L.Infof("Error: %v", err.Error())
-			time.Sleep(10 * time.Second)
-			continue
-		} else {
-			resp.Body.Close()
-			l.L.Debug("Remote couch is up.")
+		err := localCouch.Ping(ctx)
+		if err == nil || couchclient.IsUnauthorized(err) {
+			l.L.Debug("Local couch is up.")
 			break
 		}
+
+		setState(StateDegraded, "local couch is unreachable: "+err.Error())
+		wait := b.next()
+		l.L.Infof("Waiting for local couch to start, trying again in %v. Error: %v", wait, err.Error())
+		if !sleep(ctx, wait) {
+			return nerr.Create("Cancelled while waiting for local couch", "cancelled")
+		}
 	}
 
-	// wait until the local couch server is running
-	for {
-		resp, err := http.Get(addr)
-		if err != nil {
-			l.L.Info("Waiting for local couch to start...")
-			l.L.Infof("Error: %v", err.Error())
-			time.Sleep(10 * time.Second)
-			continue
+	resolveReplicationAuth(ctx)
+
+	// the remote servers being down isn't fatal, we can run off of the local config until one comes back
+	checkSources(ctx, 1)
+	for _, s := range GetSources() {
+		if s.Healthy {
+			l.L.Debugf("Remote source %v is up.", s.Name)
 		} else {
-			resp.Body.Close()
-			l.L.Debug("Local couch is up.")
-			break
+			l.L.Warnf("Remote source %v is unreachable: %v", s.Name, s.Error)
 		}
 	}
+	go watchSources(ctx)
+	go watchProgress(ctx)
+	go watchVerifications(ctx)
 
 	//check to see if we need to create all the databases
 	db := []string{
@@ -66,178 +66,184 @@ func Init() {
 		"_replicator",
 		"_users",
 	}
+	if cfg.HistoryLimit > 0 {
+		db = append(db, REPL_HISTORY_DB)
+	}
 
-	for _, d := range db {
-		err := CheckDB(d)
-		if err != nil {
-			if err.Type == "not_found" {
-				err := CreateDB(d)
-				if err != nil {
-					l.L.Debugf("%s", err.Stack)
-					l.L.Fatal(err.Addf("Couldn't initialize database %v", db))
-				}
-			} else {
-				l.L.Debugf("%s", err.Stack)
-				l.L.Fatal(err.Addf("Couldn't validate/create meta database %s", db))
-				os.Exit(1)
-			}
+	b.reset()
+	for {
+		err := createMetaDBs(ctx, db)
+		if err == nil {
+			break
 		}
+
+		setState(StateDegraded, err.Error())
+		wait := b.next()
+		l.L.Debugf("%s", err.Stack)
+		l.L.Errorf("%v. Trying again in %v", err.Error(), wait)
+		if !sleep(ctx, wait) {
+			return nerr.Create("Cancelled while creating meta databases", "cancelled")
+		}
+	}
+
+	if err := restorePosted(ctx); err != nil {
+		l.L.Warnf("Unable to tell which sources replications were posted with, they'll be checked when they're next scheduled: %v", err.Error())
+	}
+
+	// replications will fail if couch can't connect to the remote server, but everything else can still run
+	if err := configureReplicatorTLS(ctx); err != nil {
+		l.L.Warnf("Unable to configure TLS for replications: %v", err.Error())
 	}
 
-	//setup the default config
-	DefaultReplConfig.Database = REPL_CONFIG_DB
-	DefaultReplConfig.Continuous = false
-	DefaultReplConfig.Interval = 300
+	return nil
 }
 
-const (
-	WAIT_LIMIT = 60
-)
+func createMetaDBs(ctx context.Context, dbs []string) *nerr.E {
+	for _, d := range dbs {
+		err := CheckDB(ctx, d)
+		if err == nil {
+			continue
+		}
+
+		if err.Type != "not_found" {
+			return err.Addf("Couldn't validate/create meta database %s", d)
+		}
+
+		err = CreateDB(ctx, d)
+		if err != nil {
+			return err.Addf("Couldn't initialize database %v", d)
+		}
+	}
+
+	return nil
+}
 
 //Start is the entry point, this pulls down the _replication-config database, then acts on it to schedule replications
-//of all other databases applicable for this host
-func Start() *nerr.E {
+//of all other databases applicable for this host. If the config database can't be replicated, the last copy of it
+//that was replicated locally is used instead, and until there is a config to use it keeps retrying with backoff.
+func Start(ctx context.Context) *nerr.E {
 	l.L.Info("Starting replication scheduler")
 	//if we don't want to replicate, stop now.
 
-	if len(os.Getenv("STOP_REPLICATION")) > 0 {
+	if cfg.StopReplication {
 		l.L.Info("Stopping replication")
+		setState(StateStopped, "replication is stopped by the settings")
 		return nil
 	}
 
-	ReplicateReplicationConfig()
+	b := newStartupBackoff()
+	for {
+		var replErr *nerr.E
+		if replicatingConfig() {
+			replErr = ReplicateReplicationConfig(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
 
-	//Config database is there. Check for a document for this room, if none, get the default
-	config, err := GetConfig(os.Getenv("SYSTEM_ID"))
-	if err != nil {
-		return err.Add("Error getting the replication config while starting")
-	}
+		if replErr != nil {
+			l.L.Warnf("Unable to replicate %v, trying to use the local copy: %v", REPL_CONFIG_DB, replErr.Error())
+			setState(StateDegraded, replErr.Error())
+		}
+
+		//Check for a document for this room, if none, get the default
+		config, err := GetConfig(ctx, cfg.Hostname)
+		if err == nil {
+			l.L.Debugf("Configuration document retrieved, %v replications retrieved", len(config.Replications))
+			if replErr == nil {
+				setState(StateRunning, "")
+			} else {
+				setState(StateDegraded, "using the local copy of "+REPL_CONFIG_DB+": "+replErr.Error())
+			}
 
-	l.L.Debugf("Configuration document retrieved, %s replications retrieved", len(config.Replications))
+			//we have the config - we can go ahead and schedule the updates
+			return StartReplicationJobs(ctx, config)
+		}
 
-	//we have the config - we can go ahead and schedule the updates
-	return StartReplicationJobs(config)
+		if replErr == nil {
+			setState(StateDegraded, err.Error())
+		}
+
+		wait := b.next()
+		l.L.Errorf("Error getting the replication config while starting, trying again in %v: %v", wait, err.Error())
+		if !sleep(ctx, wait) {
+			return nil
+		}
+	}
 }
 
-func ReplicateReplicationConfig() {
-	err := ScheduleReplication(REPL_CONFIG_DB, false)
-	if err != nil {
-		l.L.Debugf("%s", err.Stack)
-		l.L.Fatal(err.Add("replication-config database isn't present and we can't start replication"))
+//ReplicateReplicationConfig replicates the config database, and waits up to the wait limit in seconds for it to complete
+func ReplicateReplicationConfig(ctx context.Context) *nerr.E {
+	err := ScheduleReplication(ctx, DatabaseConfig{Database: REPL_CONFIG_DB})
+	if err != nil && err.Type != "duplicate_repl" {
+		return err.Add("replication-config database replication couldn't be scheduled")
 	}
 
 	tries := 0
 	for {
 		l.L.Debugf("Waiting for replication for replication-config to succeed")
 
-		if tries >= WAIT_LIMIT {
-			l.L.Fatal("Exceeded retry limit for pulling down the replication-config database.")
+		if tries >= cfg.WaitLimit {
+			return nerr.Create("Exceeded retry limit for pulling down the replication-config database.", "timeout")
 		}
 		//waiting for the config db to replicate down
-		replID := fmt.Sprintf("auto_%v", REPL_CONFIG_DB)
-		state, err := CheckReplication(replID)
+		replID := ReplicationID(REPL_CONFIG_DB, DirectionPull)
+		state, err := CheckReplication(ctx, replID)
 		if err != nil {
-			l.L.Debugf("%s", err.Stack)
-			l.L.Fatal(err.Add("replication-config database replication failed, cannot start replication"))
+			return err.Add("replication-config database replication failed")
 		}
 
 		l.L.Debugf("State of %s db is %s", REPL_CONFIG_DB, state)
 
 		if state == "completed" {
 			l.L.Debugf("replication-config completed")
-			break
+			return nil
 		}
 
-		if state == "failed" {
-			l.L.Fatal("Replication of replication config database has failed.")
+		if failedState(state) {
+			return nerr.Create(fmt.Sprintf("Replication of replication config database has failed (%v).", state), "failed")
 		}
 
-		time.Sleep(1 * time.Second)
+		if !sleep(ctx, 1*time.Second) {
+			return nerr.Create("Cancelled while waiting for the replication-config database", "cancelled")
+		}
 		tries++
 	}
 }
 
-func CheckDB(db string) *nerr.E {
+func CheckDB(ctx context.Context, db string) *nerr.E {
 	l.L.Debugf("Checking for DB %v", db)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%v/%v", os.Getenv("COUCH_ADDR"), db), nil)
-	if err != nil {
-		return nerr.Translate(err).Add("Couldn't create request")
-	}
-
-	req.SetBasicAuth(os.Getenv("COUCH_USER"), os.Getenv("COUCH_PASS"))
-	l.L.Debugf("%s, %s", os.Getenv("COUCH_USER"), os.Getenv("COUCH_PASS"))
-	c := http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't make request for database: %v", db)
-	}
-
-	//we're all good
-	if resp.StatusCode/100 == 2 {
-		l.L.Debug("Database Present. Returning")
-		return nil
-	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't read error response from couch server while checking for DB %v", db)
-	}
-	defer resp.Body.Close()
-
-	//we can unmarshal the coucherr
-	ce := couch.CouchError{}
-	err = json.Unmarshal(b, &ce)
+	_, err := localCouch.GetDatabase(ctx, db)
 	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't Unmarshal response from couch server while checking for DB %v", db)
+		return translateCouchErr(err).Addf("Error checking for DB %v", db)
 	}
 
-	err = couch.CheckCouchErrors(ce)
-	if resp.StatusCode == 404 {
-		return nerr.Translate(err).Addf("Error checking for DB %v", db).SetType("not_found")
-	}
-
-	return nerr.Translate(err).Addf("Error checking for DB %v", db)
+	l.L.Debug("Database Present. Returning")
+	return nil
 }
 
-func CreateDB(db string) *nerr.E {
-	l.L.Debug("Creating DB %v", db)
+func CreateDB(ctx context.Context, db string) *nerr.E {
+	l.L.Debugf("Creating DB %v", db)
 
-	req, err := http.NewRequest("PUT", fmt.Sprintf("%v/%v", os.Getenv("COUCH_ADDR"), db), nil)
+	err := localCouch.CreateDatabase(ctx, db)
 	if err != nil {
-		return nerr.Translate(err).Add("Couldn't create request")
+		return translateCouchErr(err).Addf("Error creating DB %v", db)
 	}
 
-	req.SetBasicAuth(os.Getenv("COUCH_USER"), os.Getenv("COUCH_PASS"))
-	c := http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't make request to create database: %v", db)
-	}
-	defer resp.Body.Close()
+	l.L.Debug("Database Created. Returning.")
+	return nil
+}
 
-	//we're all good
-	if resp.StatusCode/100 == 2 {
-		l.L.Debug("Database Created. Returning.")
-		return nil
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't read error response from couch server while creating DB %v", db)
-	}
+//sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
 
-	//we can unmarshal the coucherr
-	ce := couch.CouchError{}
-	err = json.Unmarshal(b, &ce)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't Unmarshal response from couch server while creating DB %v", db)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
 	}
-
-	err = couch.CheckCouchErrors(ce)
-	return nerr.Translate(err).Addf("Error creating DB %v", db)
-}
-
-func Check() string {
-	log.Printf("yo")
-	return "yo"
 }
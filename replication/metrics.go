@@ -0,0 +1,134 @@
+package replication
+
+import (
+	"context"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "couch_repl"
+
+var (
+	replicationsScheduled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "replications_scheduled_total",
+		Help:      "Replication documents posted to _replicator.",
+	}, []string{"database", "direction"})
+
+	replicationsSucceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "replications_succeeded_total",
+		Help:      "Replications found completed when they were next scheduled. Continuous replications never complete, so they aren't counted.",
+	}, []string{"database", "direction"})
+
+	replicationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "replications_failed_total",
+		Help:      "Replications that couldn't be scheduled, or were found failed when they were next scheduled.",
+	}, []string{"database", "direction"})
+
+	conflictsResolved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "conflicts_resolved_total",
+		Help:      "Replication documents deleted and reposted because of a conflict.",
+	}, []string{"database", "direction"})
+
+	configReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Times a changed replication config was applied.",
+	})
+)
+
+var (
+	stateDesc = prometheus.NewDesc(metricsNamespace+"_replication_state",
+		"Current scheduler state of each replication document, 1 for the current state.",
+		[]string{"database", "replication", "state"}, nil)
+
+	sinceSuccessDesc = prometheus.NewDesc(metricsNamespace+"_seconds_since_last_success",
+		"Seconds since every replication of a job was last completed or caught up with its source.",
+		[]string{"database"}, nil)
+
+	docsReadDesc = prometheus.NewDesc(metricsNamespace+"_docs_read",
+		"Documents read by an active replication task.",
+		[]string{"database", "replication"}, nil)
+
+	docsWrittenDesc = prometheus.NewDesc(metricsNamespace+"_docs_written",
+		"Documents written by an active replication task.",
+		[]string{"database", "replication"}, nil)
+
+	changesPendingDesc = prometheus.NewDesc(metricsNamespace+"_changes_pending",
+		"Changes left to be processed by an active replication task.",
+		[]string{"database", "replication"}, nil)
+
+	verificationDesc = prometheus.NewDesc(metricsNamespace+"_verification_state",
+		"Result of the last check of each database against its source, 1 for the current result.",
+		[]string{"database", "state"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(
+		replicationsScheduled,
+		replicationsSucceeded,
+		replicationsFailed,
+		conflictsResolved,
+		configReloads,
+		jobCollector{},
+	)
+}
+
+//jobCollector collects the state of each replication job from couch when metrics are scraped
+type jobCollector struct{}
+
+func (jobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateDesc
+	ch <- sinceSuccessDesc
+	ch <- docsReadDesc
+	ch <- docsWrittenDesc
+	ch <- changesPendingDesc
+	ch <- verificationDesc
+}
+
+func (jobCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, v := range GetVerifications() {
+		ch <- prometheus.MustNewConstMetric(verificationDesc, prometheus.GaugeValue, 1, v.Database, v.State)
+	}
+
+	statuses := GetStatus(ctx)
+	dbs := make(map[string]string)
+
+	for _, status := range statuses {
+		if status.LastSuccess != nil {
+			ch <- prometheus.MustNewConstMetric(sinceSuccessDesc, prometheus.GaugeValue, time.Since(*status.LastSuccess).Seconds(), status.Database)
+		}
+
+		for _, leg := range status.Replications {
+			dbs[leg.ID] = status.Database
+			ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, 1, status.Database, leg.ID, leg.State)
+		}
+	}
+
+	tasks, err := localCouch.ReplicationTasks(ctx)
+	if err != nil {
+		l.L.Warnf("Couldn't get active tasks for metrics: %v", err)
+		return
+	}
+
+	for id, db := range dbs {
+		task, ok := tasks[id]
+		if !ok {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(docsReadDesc, prometheus.GaugeValue, float64(task.DocsRead), db, id)
+		ch <- prometheus.MustNewConstMetric(docsWrittenDesc, prometheus.GaugeValue, float64(task.DocsWritten), db, id)
+		if task.ChangesPending != nil {
+			ch <- prometheus.MustNewConstMetric(changesPendingDesc, prometheus.GaugeValue, float64(*task.ChangesPending), db, id)
+		}
+	}
+}
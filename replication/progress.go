@@ -0,0 +1,326 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//DatabaseProgress is how far along the replications of a database are
+type DatabaseProgress struct {
+	Database     string                `json:"database"`
+	Replications []ReplicationProgress `json:"replications"`
+}
+
+//ReplicationProgress is how far along a single replication is, from _active_tasks and _scheduler/jobs, and an
+//estimate of how far behind its source it is
+type ReplicationProgress struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+	State     string `json:"state"`
+	Source    string `json:"source,omitempty"`
+
+	DocsRead              int         `json:"docs_read"`
+	DocsWritten           int         `json:"docs_written"`
+	DocWriteFailures      int         `json:"doc_write_failures"`
+	ChangesPending        *int        `json:"changes_pending,omitempty"`
+	CheckpointedSourceSeq interface{} `json:"checkpointed_source_seq,omitempty"`
+
+	//SourceUpdateSeq is the source database's update_seq, and ChangesBehind is roughly how many changes it's
+	//ahead of the last checkpoint
+	SourceUpdateSeq interface{} `json:"source_update_seq,omitempty"`
+	ChangesBehind   *int64      `json:"changes_behind,omitempty"`
+
+	//UpToDate is the last time the replication was known to have caught up with its source, and LagSeconds is
+	//how long it's been behind since then
+	UpToDate   *time.Time `json:"up_to_date,omitempty"`
+	LagSeconds *float64   `json:"lag_seconds,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+//caughtUp is the last time each replication was seen caught up with its source
+var caughtUp = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func markCaughtUp(replID string, t time.Time) {
+	caughtUp.Lock()
+	defer caughtUp.Unlock()
+
+	if t.After(caughtUp.m[replID]) {
+		caughtUp.m[replID] = t
+	}
+}
+
+func lastCaughtUp(replID string) time.Time {
+	caughtUp.Lock()
+	defer caughtUp.Unlock()
+
+	return caughtUp.m[replID]
+}
+
+//replicationTasks is what couch is doing for every replication at one point in time
+type replicationTasks struct {
+	tasks map[string]couchclient.ActiveTask
+	jobs  map[string]couchclient.SchedulerJob
+}
+
+func getReplicationTasks(ctx context.Context) (replicationTasks, *nerr.E) {
+	var toReturn replicationTasks
+	var err error
+
+	toReturn.tasks, err = localCouch.ReplicationTasks(ctx)
+	if err != nil {
+		return toReturn, translateCouchErr(err).Add("Couldn't get the active tasks")
+	}
+
+	toReturn.jobs, err = localCouch.SchedulerJobs(ctx)
+	if err != nil {
+		return toReturn, translateCouchErr(err).Add("Couldn't get the scheduler jobs")
+	}
+
+	return toReturn, nil
+}
+
+//GetProgress returns the progress of the replications of every database currently being run
+func GetProgress(ctx context.Context) ([]DatabaseProgress, *nerr.E) {
+	tasks, err := getReplicationTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := trackedConfigs()
+
+	toReturn := make([]DatabaseProgress, 0, len(configs))
+	for _, config := range configs {
+		toReturn = append(toReturn, buildProgress(ctx, config, tasks))
+	}
+
+	return toReturn, nil
+}
+
+//GetDatabaseProgress returns the progress of the replications of a single database
+func GetDatabaseProgress(ctx context.Context, db string) (DatabaseProgress, *nerr.E) {
+	jobs.RLock()
+	j, ok := jobs.m[db]
+	jobs.RUnlock()
+
+	if !ok {
+		return DatabaseProgress{}, nerr.Create(fmt.Sprintf("No replication job is running for %v", db), "not_found")
+	}
+
+	tasks, err := getReplicationTasks(ctx)
+	if err != nil {
+		return DatabaseProgress{}, err
+	}
+
+	return buildProgress(ctx, j.status().Config, tasks), nil
+}
+
+func buildProgress(ctx context.Context, config DatabaseConfig, tasks replicationTasks) DatabaseProgress {
+	progress := DatabaseProgress{
+		Database:     config.Database,
+		Replications: []ReplicationProgress{},
+	}
+
+	directions, err := config.Directions()
+	if err != nil {
+		return progress
+	}
+
+	for _, direction := range directions {
+		progress.Replications = append(progress.Replications, legProgress(ctx, config, direction, tasks))
+	}
+
+	return progress
+}
+
+//legProgress fills in the progress of one replication from the live task if there is one, or from what the
+//scheduler last reported if there isn't, and compares it to its source's update_seq
+func legProgress(ctx context.Context, config DatabaseConfig, direction string, tasks replicationTasks) ReplicationProgress {
+	p := ReplicationProgress{
+		ID:        ReplicationID(config.Database, direction),
+		Direction: direction,
+	}
+	p.Source = postedSource(p.ID)
+
+	state, err := getReplicationState(ctx, p.ID)
+	if err != nil {
+		p.State = "unknown"
+		p.Error = err.Error()
+		return p
+	}
+	p.State = state.State
+
+	if task, ok := tasks.tasks[p.ID]; ok {
+		p.DocsRead = task.DocsRead
+		p.DocsWritten = task.DocsWritten
+		p.DocWriteFailures = task.DocWriteFailures
+		p.ChangesPending = task.ChangesPending
+		p.CheckpointedSourceSeq = task.CheckpointedSourceSeq
+	} else {
+		info, ok := couchclient.ParseReplicationInfo(state.Info)
+		if job, running := tasks.jobs[p.ID]; running {
+			if jobInfo, ok2 := couchclient.ParseReplicationInfo(job.Info); ok2 {
+				info, ok = jobInfo, ok2
+			}
+		}
+
+		if ok {
+			p.DocsRead = info.DocsRead
+			p.DocsWritten = info.DocsWritten
+			p.DocWriteFailures = info.DocWriteFailures
+			p.ChangesPending = info.ChangesPending
+			p.CheckpointedSourceSeq = info.CheckpointedSourceSeq
+			p.Error = info.Error
+		} else if msg, ok := state.Info.(string); ok {
+			p.Error = msg
+		}
+	}
+
+	//a completed replication was caught up when it finished
+	if p.State == "completed" {
+		markCaughtUp(p.ID, state.LastUpdated)
+	}
+
+	dbInfo, err := sourceDatabase(ctx, config, direction)
+	if err != nil {
+		if len(p.Error) == 0 {
+			p.Error = err.Error()
+		}
+	} else {
+		p.SourceUpdateSeq = dbInfo.UpdateSeq
+
+		latest, ok := seqNumber(dbInfo.UpdateSeq)
+		checkpointed, ok2 := seqNumber(p.CheckpointedSourceSeq)
+		if ok && ok2 {
+			behind := latest - checkpointed
+			if behind < 0 {
+				behind = 0
+			}
+			p.ChangesBehind = &behind
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case p.ChangesBehind != nil && *p.ChangesBehind == 0:
+		markCaughtUp(p.ID, now)
+	case p.State == "running" && p.ChangesPending != nil && *p.ChangesPending == 0 && p.ChangesBehind == nil:
+		markCaughtUp(p.ID, now)
+	}
+
+	if t := lastCaughtUp(p.ID); !t.IsZero() {
+		lag := now.Sub(t).Seconds()
+		p.UpToDate = &t
+		p.LagSeconds = &lag
+	}
+
+	return p
+}
+
+//sourceDatabase gets the info of the database a replication reads from
+func sourceDatabase(ctx context.Context, config DatabaseConfig, direction string) (couchclient.DatabaseInfo, *nerr.E) {
+	if direction == DirectionPush {
+		info, err := localCouch.GetDatabase(ctx, config.TargetDB())
+		if err != nil {
+			return info, translateCouchErr(err).Addf("Couldn't get the local %v database", config.TargetDB())
+		}
+		return info, nil
+	}
+
+	src, nerror := replicationSource(config)
+	if nerror != nil {
+		return couchclient.DatabaseInfo{}, nerror
+	}
+
+	info, err := src.client.GetDatabase(ctx, config.SourceDB())
+	if err != nil {
+		return info, translateCouchErr(err).Addf("Couldn't get the %v database from %v", config.SourceDB(), src.name)
+	}
+
+	return info, nil
+}
+
+//seqNumber gets the numeric part of a sequence. Couch 1 uses plain numbers, and later versions use strings that start
+//with a number followed by an opaque part, so the numbers are only an estimate of how many changes there have been.
+func seqNumber(seq interface{}) (int64, bool) {
+	switch v := seq.(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		if i := strings.Index(v, "-"); i >= 0 {
+			v = v[:i]
+		}
+
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+//logProgress logs the progress of every replication
+func logProgress(ctx context.Context) {
+	progress, err := GetProgress(ctx)
+	if err != nil {
+		l.L.Warnf("Couldn't get replication progress: %v", err.Error())
+		return
+	}
+
+	for _, db := range progress {
+		for _, p := range db.Replications {
+			pending, behind, lag := "unknown", "unknown", "unknown"
+			if p.ChangesPending != nil {
+				pending = strconv.Itoa(*p.ChangesPending)
+			}
+			if p.ChangesBehind != nil {
+				behind = strconv.FormatInt(*p.ChangesBehind, 10)
+			}
+			if p.LagSeconds != nil {
+				lag = (time.Duration(*p.LagSeconds) * time.Second).String()
+			}
+
+			msg := fmt.Sprintf("Progress of %v (%v): %v docs read, %v written, %v changes pending, %v changes behind, %v behind",
+				p.ID, p.State, p.DocsRead, p.DocsWritten, pending, behind, lag)
+			if len(p.Error) > 0 {
+				msg += ". Error: " + p.Error
+			}
+
+			if p.ChangesBehind != nil && *p.ChangesBehind == 0 {
+				l.L.Debug(msg)
+			} else {
+				l.L.Info(msg)
+			}
+		}
+	}
+}
+
+//watchProgress logs the progress of every replication on the progress interval, until ctx is cancelled
+func watchProgress(ctx context.Context) {
+	if cfg.ProgressInterval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(time.Duration(cfg.ProgressInterval) * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		logProgress(ctx)
+	}
+}
@@ -0,0 +1,144 @@
+package replication
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+func TestSeqNumber(t *testing.T) {
+	tests := []struct {
+		seq  interface{}
+		want int64
+		ok   bool
+	}{
+		{float64(42), 42, true},
+		{"42", 42, true},
+		{"42-g1AAAAFTeJzLYWBg4MhgTmHgz8tPSTV0MDQy1zMAQsMckEQiQ1L9____szKYE1lygQLsZiYmKRYmadj0YDEjjwVIMjQAqf9QwxjBhqUkJSdbJmPTkwUAm8Qkqw", 42, true},
+		{"g1AAAAFTeJzLYWBg4MhgTmHgz8tPSTV0MDQy1zMAQsMckEQiQ1L9", 0, false},
+		{"", 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := seqNumber(tt.seq)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("got %v, %v for %v, want %v, %v", got, ok, tt.seq, tt.want, tt.ok)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestLegProgress(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       string
+		lastUpdated time.Duration
+		task        *couchclient.ActiveTask
+		sourceSeq   interface{}
+		behind      int64
+		noBehind    bool
+		lag         float64
+		noLag       bool
+	}{
+		{
+			name:      "behind",
+			state:     "running",
+			task:      &couchclient.ActiveTask{CheckpointedSourceSeq: "40-abc"},
+			sourceSeq: "55-xyz",
+			behind:    15,
+			noLag:     true,
+		},
+		{
+			name:      "behind couch 1",
+			state:     "triggered",
+			task:      &couchclient.ActiveTask{CheckpointedSourceSeq: float64(40)},
+			sourceSeq: float64(55),
+			behind:    15,
+			noLag:     true,
+		},
+		{
+			name:      "checkpointed past the update_seq",
+			state:     "running",
+			task:      &couchclient.ActiveTask{CheckpointedSourceSeq: "60-abc"},
+			sourceSeq: "55-xyz",
+			behind:    0,
+			lag:       0,
+		},
+		{
+			name:      "opaque sequences",
+			state:     "running",
+			task:      &couchclient.ActiveTask{CheckpointedSourceSeq: "g1AAAA", ChangesPending: intPtr(3)},
+			sourceSeq: "55-xyz",
+			noBehind:  true,
+			noLag:     true,
+		},
+		{
+			name:      "nothing pending",
+			state:     "running",
+			task:      &couchclient.ActiveTask{ChangesPending: intPtr(0)},
+			sourceSeq: "g1AAAA",
+			noBehind:  true,
+			lag:       0,
+		},
+		{
+			name:        "completed a minute ago",
+			state:       "completed",
+			lastUpdated: -time.Minute,
+			sourceSeq:   "55-xyz",
+			noBehind:    true,
+			lag:         60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := newFakeCouch(t), newFakeCouch(t)
+			useLocalCouch(t, local)
+			useRemoteCouch(t, remote)
+
+			config := DatabaseConfig{Database: "rooms"}
+			replID := ReplicationID(config.Database, DirectionPull)
+
+			caughtUp.Lock()
+			delete(caughtUp.m, replID)
+			caughtUp.Unlock()
+
+			remote.createDB("rooms")
+			remote.setSeq("rooms", tt.sourceSeq)
+
+			doc := couchclient.SchedulerDoc{DocID: replID, State: tt.state}
+			if tt.lastUpdated != 0 {
+				doc.LastUpdated = time.Now().Add(tt.lastUpdated)
+			}
+			local.setScheduler(doc)
+
+			tasks := replicationTasks{tasks: map[string]couchclient.ActiveTask{}}
+			if tt.task != nil {
+				tasks.tasks[replID] = *tt.task
+			}
+
+			p := legProgress(context.Background(), config, DirectionPull, tasks)
+
+			switch {
+			case tt.noBehind && p.ChangesBehind != nil:
+				t.Errorf("got %v changes behind, want it unknown", *p.ChangesBehind)
+			case !tt.noBehind && (p.ChangesBehind == nil || *p.ChangesBehind != tt.behind):
+				t.Errorf("got changes behind %v, want %v", p.ChangesBehind, tt.behind)
+			}
+
+			switch {
+			case tt.noLag && p.LagSeconds != nil:
+				t.Errorf("got a lag of %v seconds, want it unknown", *p.LagSeconds)
+			case !tt.noLag && (p.LagSeconds == nil || math.Abs(*p.LagSeconds-tt.lag) > 5):
+				t.Errorf("got a lag of %v seconds, want about %v", p.LagSeconds, tt.lag)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"sigs.k8s.io/yaml"
+)
+
+//Places the replication config can be read from
+const (
+	//ConfigSourceCouch reads the config from the replication-config database, which is replicated from the remote
+	//couch server. This is the default.
+	ConfigSourceCouch = "couch"
+
+	//ConfigSourceFile reads the config from a JSON or YAML file
+	ConfigSourceFile = "file"
+
+	//ConfigSourceEnv reads the config from a JSON or YAML document in the environment
+	ConfigSourceEnv = "env"
+)
+
+//ConfigProvider is where replication config documents come from
+type ConfigProvider interface {
+	//GetConfigDoc returns the config document with id, or a not_found error if there isn't one
+	GetConfigDoc(ctx context.Context, id string) (ReplicationConfig, *nerr.E)
+}
+
+//CouchConfigProvider reads config documents from the local copy of the replication-config database
+type CouchConfigProvider struct{}
+
+//GetConfigDoc implements ConfigProvider
+func (CouchConfigProvider) GetConfigDoc(ctx context.Context, id string) (ReplicationConfig, *nerr.E) {
+	toReturn := ReplicationConfig{}
+
+	err := localCouch.GetDocument(ctx, REPL_CONFIG_DB, id, &toReturn)
+	if err != nil {
+		l.L.Debugf("Unable to retrieve config document %v", id)
+		return toReturn, translateCouchErr(err).Addf("Couldn't get the configuration document %v", id)
+	}
+
+	return toReturn, nil
+}
+
+//FileConfigProvider reads config documents from a JSON or YAML file holding either a single document or a list of
+//them. The file is read every time, so changes to it are picked up the next time the config is checked.
+type FileConfigProvider struct {
+	Path string
+}
+
+//GetConfigDoc implements ConfigProvider
+func (p FileConfigProvider) GetConfigDoc(ctx context.Context, id string) (ReplicationConfig, *nerr.E) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return ReplicationConfig{}, nerr.Translate(err).Addf("Couldn't read config file %v", p.Path)
+	}
+
+	docs, nerror := parseConfigDocs(data)
+	if nerror != nil {
+		return ReplicationConfig{}, nerror.Addf("Couldn't parse config file %v", p.Path)
+	}
+
+	return findConfigDoc(docs, id)
+}
+
+//StaticConfigProvider serves config documents that were loaded once at startup
+type StaticConfigProvider struct {
+	Docs []ReplicationConfig
+}
+
+//NewStaticConfigProvider builds a provider from a JSON or YAML document, or list of documents
+func NewStaticConfigProvider(data []byte) (*StaticConfigProvider, *nerr.E) {
+	docs, err := parseConfigDocs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticConfigProvider{Docs: docs}, nil
+}
+
+//GetConfigDoc implements ConfigProvider
+func (p *StaticConfigProvider) GetConfigDoc(ctx context.Context, id string) (ReplicationConfig, *nerr.E) {
+	return findConfigDoc(p.Docs, id)
+}
+
+//parseConfigDocs parses either a single config document or a list of them. A single document without an _id is
+//used as the default document.
+func parseConfigDocs(data []byte) ([]ReplicationConfig, *nerr.E) {
+	var docs []ReplicationConfig
+	if err := yaml.Unmarshal(data, &docs); err == nil {
+		return docs, nil
+	}
+
+	var doc ReplicationConfig
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nerr.Translate(err).Add("Invalid config document").SetType("invalid_args")
+	}
+
+	if len(doc.ID) == 0 {
+		doc.ID = "default"
+	}
+
+	return []ReplicationConfig{doc}, nil
+}
+
+func findConfigDoc(docs []ReplicationConfig, id string) (ReplicationConfig, *nerr.E) {
+	for _, doc := range docs {
+		if doc.ID == id {
+			return doc, nil
+		}
+	}
+
+	return ReplicationConfig{}, nerr.Create(fmt.Sprintf("No configuration document %v", id), "not_found")
+}
+
+var configProvider ConfigProvider = CouchConfigProvider{}
+
+//SetConfigProvider replaces where config documents are read from
+func SetConfigProvider(p ConfigProvider) {
+	configProvider = p
+}
+
+//replicatingConfig reports whether config documents come from the replication-config database, in which case it
+//needs to be replicated from the remote couch server
+func replicatingConfig() bool {
+	_, ok := configProvider.(CouchConfigProvider)
+	return ok
+}
+
+//initConfigProvider sets up the config provider from the config source setting
+func initConfigProvider() *nerr.E {
+	switch cfg.ConfigSource {
+	case ConfigSourceCouch:
+		SetConfigProvider(CouchConfigProvider{})
+	case ConfigSourceFile:
+		path := cfg.ConfigFile
+		if _, err := os.Stat(path); err != nil {
+			return nerr.Translate(err).Addf("Couldn't find config file %v", path).SetType("invalid_args")
+		}
+
+		SetConfigProvider(FileConfigProvider{Path: path})
+	case ConfigSourceEnv:
+		p, err := NewStaticConfigProvider([]byte(cfg.ConfigDocument))
+		if err != nil {
+			return err.Add("Invalid CONFIG_DOCUMENT")
+		}
+
+		SetConfigProvider(p)
+	default:
+		return nerr.Create(fmt.Sprintf("Invalid config source %q", cfg.ConfigSource), "invalid_args")
+	}
+
+	return nil
+}
@@ -1,394 +1,428 @@
 package replication
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/byuoitav/common/db/couch"
 	l "github.com/byuoitav/common/log"
 	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
 )
 
-type couchReplicationState struct {
-	Database    string    `json:"database"`
-	DocID       string    `json:"doc_id"`
-	ID          string    `json:"id"`
-	Source      string    `json:"source"`
-	Target      string    `json:"target"`
-	State       string    `json:"state"`
-	ErrorCount  int       `json:"error_count"`
-	StartTime   time.Time `json:"start_time"`
-	LastUpdated time.Time `json:"last_updated"`
-}
-
-type couchReplicationPayload struct {
-	ID           string      `json:"_id"`
-	Rev          string      `json:"_rev,omitempty"`
-	Source       string      `json:"source"`
-	Target       string      `json:"target"`
-	CreateTarget bool        `json:"create_target"`
-	Continuous   bool        `json:"continous"`
-	Selector     interface{} `json:"selector,omitempty"`
-	Filter       string      `json:"filter,omitempty"`
-}
-
-type idSelector struct {
-	ID regexQuery `json:"_id"`
-}
+func ReplicateNow(ctx context.Context) *nerr.E {
 
-type regexQuery struct {
-	Regex string `json:"$regex"`
-}
-
-var PI_HOSTNAME = os.Getenv("SYSTEM_ID")
-var COUCH_ADDR = os.Getenv("COUCH_ADDR")
-var COUCH_USER = os.Getenv("COUCH_USER")
-var COUCH_PASS = os.Getenv("COUCH_PASS")
-var COUCH_REPL_ADDR = os.Getenv("COUCH_REPL_ADDR")
-var COUCH_REPL_USER = os.Getenv("COUCH_REPL_USER")
-var COUCH_REPL_PASS = os.Getenv("COUCH_REPL_PASS")
-
-func ReplicateNow() *nerr.E {
-
-	if len(os.Getenv("STOP_REPLICATION")) > 0 {
-		l.L.Infof("Not replicating due to the stop replication env variable")
+	if cfg.StopReplication {
+		l.L.Infof("Not replicating due to the stop replication setting")
 		return nil
 	}
 
 	//Config database is there. Check for a document for this room, if none, get the default
-	config, err := GetConfig(PI_HOSTNAME)
+	config, err := GetConfig(ctx, cfg.Hostname)
 	if err != nil {
 		return err.Add("Error getting the replication config, could not start immediate replication.")
 	}
 
 	//we have the config - we can go ahead and schedule the updates
 	for i := range config.Replications {
-		ScheduleReplication(config.Replications[i].Database, false) // nolint:errcheck
+		if err := ScheduleReplication(ctx, config.Replications[i]); err != nil {
+			l.L.Warnf("Couldn't schedule immediate replication of %v: %v", config.Replications[i].Database, err.Error())
+		}
 	}
 
 	return nil
 }
 
-func postReplication(repl couchReplicationPayload) *nerr.E {
-	l.L.Debugf("Posting replication of %v", repl.ID)
+//Outcomes of scheduling a replication document
+const (
+	LegScheduled = "scheduled"
+	LegRunning   = "already_running"
+	LegFailed    = "failed"
+)
 
-	b, err := json.Marshal(repl)
-	if err != nil {
-		l.L.Debug("Couldn't marshal")
-		return nerr.Translate(err).Addf("Couldn't marshal payload to start replication for %v", repl.ID)
+//ReplicateDatabaseNow schedules a replication of a single database, using the config of its running job or,
+//if there isn't one, the config for this host. It returns the outcome of each replication document. The error is
+//duplicate_repl only if every one of them was already running, and is otherwise the first failure.
+func ReplicateDatabaseNow(ctx context.Context, db string) (map[string]string, *nerr.E) {
+	if cfg.StopReplication {
+		return nil, nerr.Create("Not replicating due to the stop replication setting", "stopped")
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%v/_replicator", COUCH_ADDR), bytes.NewReader(b))
+	config, err := databaseConfig(ctx, db)
 	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't create request to start replication of %v", repl.ID)
+		return nil, err.Addf("Couldn't start immediate replication of %v", db)
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-
-	req.SetBasicAuth(COUCH_USER, COUCH_PASS)
-	c := http.Client{}
-	resp, err := c.Do(req)
+	errs, err := scheduleLegs(ctx, config)
 	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't make request to start replication of %v", repl.ID)
+		return nil, err
+	}
+
+	outcomes := make(map[string]string, len(errs))
+	var duplicate, failed *nerr.E
+	for _, id := range config.ReplicationIDs() {
+		err := errs[id]
+		switch {
+		case err == nil:
+			outcomes[id] = LegScheduled
+		case err.Type == "duplicate_repl":
+			outcomes[id] = LegRunning
+			duplicate = err
+		default:
+			outcomes[id] = LegFailed
+			if failed == nil {
+				failed = err
+			}
+		}
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode/100 == 2 {
-		l.L.Debugf("Replication for %v scheduled.", repl.ID)
-		return nil
-	}
-	if resp.StatusCode == 409 {
-		return nerr.Create("Conflict while creating the replication.", "conflict")
+	switch {
+	case failed != nil:
+		return outcomes, failed
+	case duplicate != nil && allRunning(outcomes):
+		return outcomes, duplicate
 	}
 
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't read error response from couch server while creating replication %v", repl.ID)
-	}
+	return outcomes, nil
+}
 
-	ce := couch.CouchError{}
-	err = json.Unmarshal(b, &ce)
-	if err != nil {
-		return nerr.Translate(err).Addf("Couldn't Unmarshal response from couch server while creating replication %v", repl.ID)
+func allRunning(outcomes map[string]string) bool {
+	for _, outcome := range outcomes {
+		if outcome != LegRunning {
+			return false
+		}
 	}
-	err = couch.CheckCouchErrors(ce)
 
-	return nerr.Translate(err).Addf("Couldn't post replication document")
+	return true
 }
 
-func ScheduleReplication(db string, continuous bool) *nerr.E {
-	replID := fmt.Sprintf("auto_%v", db)
-
-	//check to see if a replication for this database is already running. If so. check the state.
-	status, err := CheckReplication(replID)
-
+//WaitForReplication blocks until every replication document for db is completed or failed, or ctx is done. Continuous
+//replications never complete, so they're done once they're running with no changes pending.
+//It returns the last seen state of each replication document.
+func WaitForReplication(ctx context.Context, db string) (map[string]string, *nerr.E) {
+	config, err := databaseConfig(ctx, db)
 	if err != nil {
-		return err.Addf("Couldn't schedule replication of %v", db)
+		return nil, err.Addf("Couldn't wait for replication of %v", db)
 	}
 
-	if status == "running" || status == "started" || status == "added" {
-		return nerr.Create(fmt.Sprintf("Replication for %v running. In state %v.", db, status), "duplicate_repl")
-	}
+	states := make(map[string]string)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 
-	l.L.Debugf("Replication state: %v", status)
-
-	//check to make sure the filter is there
-	//filterName := fmt.Sprintf("filters/%v", replID)
-	//filterName := "filters/deletedfilter"
-	//filterName := ""
-	/*
-		err = CheckForReplicationFilter(db)
-
-		if err == nil {
-			l.L.Debugf("Error when checking for filter for %v", db)
-			filterName = ""
-		}*/
+	for {
+		var tasks map[string]couchclient.ActiveTask
+		if config.Continuous {
+			var terr error
+			tasks, terr = localCouch.ReplicationTasks(ctx)
+			if terr != nil {
+				l.L.Warnf("Couldn't get the active tasks while waiting for replication of %v: %v", db, terr)
+			}
+		}
 
-	//we can create the replication
-	rdoc := couchReplicationPayload{
-		ID:           replID,
-		Source:       fmt.Sprintf("%v/%v", insertReplCreds(COUCH_REPL_ADDR), db),
-		Target:       fmt.Sprintf("%v/%v", insertLocalCreds(COUCH_ADDR), db),
-		CreateTarget: true,
-		Continuous:   continuous,
-		//Filter:       filterName,
-	}
+		done := true
+		failed := false
+		for _, id := range config.ReplicationIDs() {
+			state, err := CheckReplication(ctx, id)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return states, nerr.Create(fmt.Sprintf("Timed out waiting for replication of %v", db), "timeout")
+			case err != nil:
+				return states, err.Addf("Couldn't wait for replication of %v", db)
+			}
 
-	// Filter devices table for only room specific devices
-	if db == "devices" {
-		pieces := strings.Split(PI_HOSTNAME, "-")
-		rdoc.Selector = idSelector{
-			ID: regexQuery{
-				Regex: fmt.Sprintf("%s-%s-", pieces[0], pieces[1]),
-			},
+			states[id] = state
+			switch {
+			case state == "completed":
+			case state == "running" && caughtUpTask(tasks, id):
+			case failedState(state):
+				failed = true
+			default:
+				done = false
+			}
 		}
-	}
 
-	err = postReplication(rdoc)
-	if err == nil {
-		l.L.Debugf("Replication for %v started successfully", db)
-		return nil
-	}
-	switch err.Type {
-	case "conflict":
-		//We delete the document, and try again
-		err = deleteReplication(replID)
-		if err != nil {
-			return err.Addf("Couldn't delete the replication document to schedule a new replication for %v", db)
+		if done && failed {
+			return states, nerr.Create(fmt.Sprintf("Replication of %v failed", db), "failed")
 		}
-		err = postReplication(rdoc)
-		if err != nil {
-			return err.Addf("Schedling replication for %v after deleting old replication failed", db)
+		if done {
+			return states, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return states, nerr.Create(fmt.Sprintf("Timed out waiting for replication of %v", db), "timeout")
+		case <-ticker.C:
 		}
-	default:
-		return err.Addf("Couldn't schedule replication for datbase: %v", db)
 	}
-	return nil
+}
 
+//caughtUpTask is true if the active task for replID has no changes pending
+func caughtUpTask(tasks map[string]couchclient.ActiveTask, replID string) bool {
+	task, ok := tasks[replID]
+	return ok && task.ChangesPending != nil && *task.ChangesPending == 0
 }
 
-/*
-func CheckForReplicationFilter(db string) *nerr.E {
-	l.L.Debugf("Checking to see if replication filter for %v exists", db)
+//databaseConfig finds the config for a database, first from its running job and then from this host's config
+func databaseConfig(ctx context.Context, db string) (DatabaseConfig, *nerr.E) {
+	jobs.RLock()
+	j, ok := jobs.m[db]
+	jobs.RUnlock()
 
-	headers := map[string]string{
-		"Authorization": "Basic " + jsonhttp.BasicAuth(COUCH_USER, COUCH_PASS),
+	if ok {
+		return j.status().Config, nil
 	}
 
-	responseBody, response, responseErr := jsonhttp.CreateAndExecuteJSONRequest("Check Existence of Filter", "GET", fmt.Sprintf("%v/%v/_design/filter", COUCH_ADDR, db), "", headers, 30, nil)
+	config, err := GetConfig(ctx, cfg.Hostname)
+	if err != nil {
+		return DatabaseConfig{}, err.Add("Error getting the replication config")
+	}
 
-	if responseErr != nil {
-		return nerr.Translate(responseErr).Addf("Couldn't check existence of filter for database %v", db)
+	for i := range config.Replications {
+		if config.Replications[i].Database == db {
+			return config.Replications[i], nil
+		}
 	}
 
-	l.L.Debugf("Response received from couch while checking filter: %s", responseBody)
+	return DatabaseConfig{}, nerr.Create(fmt.Sprintf("%v isn't configured to replicate on %v", db, cfg.Hostname), "not_found")
+}
 
-	if response.StatusCode == 404 {
-		//Check if its already there
-		responseBody, response, responseErr = jsonhttp.CreateAndExecuteJSONRequest("Check Existence of Database", "HEAD", fmt.Sprintf("%v/%v", COUCH_ADDR, db), "", headers, 30, nil)
+func postReplication(ctx context.Context, repl couchclient.ReplicationDoc) *nerr.E {
+	l.L.Debugf("Posting replication of %v", repl.ID)
 
-		if responseErr != nil {
-			return nerr.Translate(responseErr).Addf("Couldn't check existence of database %v", db)
+	err := localCouch.PostReplication(ctx, repl)
+	if err != nil {
+		if couchclient.IsConflict(err) {
+			return nerr.Create("Conflict while creating the replication.", "conflict")
 		}
 
-		if response.StatusCode/100 == 4 {
-			//It isn't, create one
-			responseBody, response, responseErr = jsonhttp.CreateAndExecuteJSONRequest("Create Database", "PUT", fmt.Sprintf("%v/%v", COUCH_ADDR, db), "", headers, 30, nil)
+		return translateCouchErr(err).Addf("Couldn't post replication document")
+	}
 
-			if responseErr != nil {
-				return nerr.Translate(responseErr).Addf("Couldn't create database %v", db)
-			}
+	l.L.Debugf("Replication for %v scheduled.", repl.ID)
+	return nil
+}
 
-			l.L.Debugf("Response received from couch while create database: %s", responseBody)
+//ScheduleReplication schedules a replication for each direction in the database config. Every leg is attempted,
+//and the first error encountered is returned.
+func ScheduleReplication(ctx context.Context, config DatabaseConfig) *nerr.E {
+	errs, err := scheduleLegs(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range config.ReplicationIDs() {
+		if errs[id] != nil {
+			return errs[id]
 		}
+	}
 
-		//we need to go ahead and create one
-		headers["Content-Type"] = "application/json"
-		json := "{\"_id\": \"_design/filters\", \"filters\": { \"deletedfilter\": \"function(doc, req) { return !doc._deleted; };\" } }"
-		responseBody, response, responseErr = jsonhttp.CreateAndExecuteJSONRequest("Create Filter Document", "PUT", fmt.Sprintf("%v/%v/_design/filter", COUCH_ADDR, db), json, headers, 30, nil)
+	return nil
+}
 
-		if responseErr != nil {
-			return nerr.Translate(responseErr).Addf("Couldn't create filter document in db %v", db)
-		}
+//scheduleLegs schedules a replication for each direction in the database config, and returns the error each one
+//ended with by replication id
+func scheduleLegs(ctx context.Context, config DatabaseConfig) (map[string]*nerr.E, *nerr.E) {
+	directions, err := config.Directions()
+	if err != nil {
+		return nil, err.Addf("Couldn't schedule replication of %v", config.Database)
+	}
 
-		l.L.Debugf("Response received from couch while posting new filter: %s", responseBody)
+	errs := make(map[string]*nerr.E, len(directions))
+	for _, direction := range directions {
+		replID := ReplicationID(config.Database, direction)
 
-		if response.StatusCode/100 != 2 {
-			return nerr.Create(fmt.Sprintf("Non-200 response code when creating filter for %v", db), "response-received")
+		err := scheduleReplicationLeg(ctx, config, direction)
+		switch {
+		case err == nil:
+			replicationsScheduled.WithLabelValues(config.Database, direction).Inc()
+			observeState(config.Database, replID, "added", "")
+		case err.Type != "duplicate_repl":
+			replicationsFailed.WithLabelValues(config.Database, direction).Inc()
+			publishScheduleError(config.Database, replID, err)
 		}
+
+		errs[replID] = err
 	}
 
-	return nil
+	return errs, nil
 }
-*/
 
-func CheckReplication(replID string) (string, *nerr.E) {
-	l.L.Debugf("Checking to see if replication document %v is already scheduled", replID)
+func scheduleReplicationLeg(ctx context.Context, config DatabaseConfig, direction string) *nerr.E {
+	db := config.Database
+	replID := ReplicationID(db, direction)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%v/_scheduler/docs/_replicator/%v", COUCH_ADDR, replID), nil)
+	src, err := replicationSource(config)
 	if err != nil {
-		return "", nerr.Translate(err).Addf("Couldn't create request to check replication of %v", replID)
+		return err.Addf("Couldn't schedule replication of %v", db)
+	}
+
+	//we can create the replication
+	rdoc := couchclient.ReplicationDoc{
+		ID:           replID,
+		Source:       endpoint(src.client, config.SourceDB()),
+		Target:       endpoint(localCouch, config.TargetDB()),
+		CreateTarget: true,
+		Continuous:   config.Continuous,
 	}
 
-	req.SetBasicAuth(COUCH_USER, COUCH_PASS)
-	c := http.Client{}
-	resp, err := c.Do(req)
+	//pushes go the other way, and shouldn't create databases on the central server
+	if direction == DirectionPush {
+		rdoc.Source, rdoc.Target = rdoc.Target, rdoc.Source
+		rdoc.CreateTarget = false
+	}
+
+	// Limit the documents replicated to the ones this host cares about
+	err = applyFilter(&rdoc, config, cfg.Hostname)
 	if err != nil {
-		return "", nerr.Translate(err).Addf("Couldn't make request to check replication of %v", replID)
+		return err.Addf("Couldn't schedule replication of %v", db)
 	}
 
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	//check to see if a replication for this database is already running. If so. check the state.
+	status, err := CheckReplication(ctx, replID)
+
 	if err != nil {
-		return "", nerr.Translate(err).Addf("Couldn't read error response from couch server while checking for replication %v", replID)
+		return err.Addf("Couldn't schedule replication of %v", db)
 	}
 
-	if resp.StatusCode/100 != 2 {
-		ce := couch.CouchError{}
-		err = json.Unmarshal(b, &ce)
-		if err != nil {
-			return "", nerr.Translate(err).Addf("Couldn't Unmarshal response from couch server while checking for replication job %v", replID)
+	switch {
+	case inProgressState(status):
+		stale, reason := replicationStale(ctx, rdoc, src)
+		if !stale {
+			return nerr.Create(fmt.Sprintf("Replication for %v running. In state %v.", replID, status), "duplicate_repl")
 		}
 
-		err = couch.CheckCouchErrors(ce)
-		if _, ok := err.(*couch.NotFound); resp.StatusCode == 404 && ok {
-			return "not_started", nil
-		} else {
-			return "", nerr.Translate(err).Addf("Issue checking replication status of %v", replID)
+		l.L.Infof("Replacing replication %v, %v", replID, reason)
+	case failedState(status):
+		l.L.Infof("Replacing replication %v, it's %v", replID, status)
+	}
+
+	l.L.Debugf("Replication state: %v", status)
+
+	//this is the last chance to see how the previous replication went before it's replaced
+	switch {
+	case status == "completed":
+		replicationsSucceeded.WithLabelValues(db, direction).Inc()
+
+		//it may have finished since the states were last checked
+		observeState(db, replID, status, "")
+	case failedState(status):
+		replicationsFailed.WithLabelValues(db, direction).Inc()
+	}
+
+	//the run being replaced won't be in the scheduler anymore
+	if status != "not_started" {
+		recordReplaced(ctx, config, direction)
+	}
+
+	err = postReplication(ctx, rdoc)
+	if err == nil {
+		l.L.Debugf("Replication %v for %v started successfully", replID, db)
+		markPosted(replID, src.name)
+		return nil
+	}
+	switch err.Type {
+	case "conflict":
+		//We delete the document, and try again
+		err = deleteReplication(ctx, replID)
+		if err != nil {
+			return err.Addf("Couldn't delete the replication document to schedule a new replication for %v", db)
 		}
+		err = postReplication(ctx, rdoc)
+		if err != nil {
+			return err.Addf("Schedling replication for %v after deleting old replication failed", db)
+		}
+		conflictsResolved.WithLabelValues(db, direction).Inc()
+		markPosted(replID, src.name)
+	default:
+		return err.Addf("Couldn't schedule replication for datbase: %v", db)
 	}
-	//if it's a 200 response, lets see what the state is
-	state := couchReplicationState{}
-	err = json.Unmarshal(b, &state)
+	return nil
+
+}
+
+//CheckReplication gets the state of a replication document. Any state couch 1.x or 2.x+ can report is returned
+//without an error, along with not_started when the document doesn't exist.
+func CheckReplication(ctx context.Context, replID string) (string, *nerr.E) {
+	state, err := getReplicationState(ctx, replID)
 	if err != nil {
-		return "", nerr.Translate(err).Addf("Couldn't unmarshal the replication state of %v", replID)
-	}
-	switch state.State {
-	case "completed":
-		return "completed", nil
-	case "running":
-		return "running", nil
-	case "started":
-		return "started", nil
-	case "added":
-		return "added", nil
-	case "failed":
-		return "failed", nil
-	case "crashed":
-		return "crashed", nil
-	case "initializing":
-		return "initializing", nil
+		return "", err
+	}
+
+	switch {
+	case state.State == "completed":
+		markCaughtUp(replID, state.LastUpdated)
+		return state.State, nil
+	case state.State == "not_started", inProgressState(state.State), failedState(state.State):
+		return state.State, nil
 	default:
 		l.L.Errorf("Replication state for %v is in a bad state %v", replID, state.State)
 		return state.State, nerr.Create(fmt.Sprintf("Replication of %v is in state %v", replID, state.State), "couch-repl-error")
 	}
 }
 
-func getReplication(id string) (couchReplicationPayload, *nerr.E) {
-	toReturn := couchReplicationPayload{}
-	l.L.Debugf("Getting replication %v", id)
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("%v/_replicator/%v", COUCH_ADDR, id), nil)
-	if err != nil {
-		return toReturn, nerr.Translate(err).Addf("Couldn't get replication %v", id)
+//inProgressState is true for the states of a replication that's still going, or waiting on the scheduler to start it
+func inProgressState(state string) bool {
+	switch state {
+	case "running", "pending", "initializing", "started", "added":
+		return true
 	}
 
-	req.SetBasicAuth(COUCH_USER, COUCH_PASS)
-	c := http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return toReturn, nerr.Translate(err).Addf("Couldn't make request to get replication %v", id)
-	}
+	return false
+}
 
-	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return toReturn, nerr.Translate(err).Addf("Couldn't read response while getting the replication %v", id)
+//failedState is true for the states of a replication that's given up, or keeps crashing and should be replaced
+func failedState(state string) bool {
+	switch state {
+	case "failed", "crashing", "error", "crashed":
+		return true
 	}
 
-	if resp.StatusCode/100 == 2 {
-		err := json.Unmarshal(b, &toReturn)
-		if err != nil {
-			return toReturn, nerr.Translate(err).Add("Couldn't unmarshal replication into a replication")
+	return false
+}
+
+//getReplicationState gets the scheduler's view of a replication document. If the document doesn't exist, the
+//returned state is not_started.
+func getReplicationState(ctx context.Context, replID string) (couchclient.SchedulerDoc, *nerr.E) {
+	l.L.Debugf("Checking to see if replication document %v is already scheduled", replID)
+
+	state, err := localCouch.GetSchedulerDoc(ctx, replID)
+	if err != nil {
+		if couchclient.IsNotFound(err) {
+			state.DocID = replID
+			state.State = "not_started"
+			return state, nil
 		}
-		return toReturn, nil
+
+		return state, translateCouchErr(err).Addf("Issue checking replication status of %v", replID)
 	}
 
-	ce := couch.CouchError{}
-	err = json.Unmarshal(b, &ce)
+	return state, nil
+}
+
+func getReplication(ctx context.Context, id string) (couchclient.ReplicationDoc, *nerr.E) {
+	l.L.Debugf("Getting replication %v", id)
+
+	repl, err := localCouch.GetReplication(ctx, id)
 	if err != nil {
-		return toReturn, nerr.Translate(err).Addf("Couldn't unmarshal response from couch server while checking for replication doc %v", id)
+		return repl, translateCouchErr(err).Addf("Couldn't get replication document")
 	}
 
-	err = couch.CheckCouchErrors(ce)
-	return toReturn, nerr.Translate(err).Addf("Couldn't get replication document")
+	return repl, nil
 }
 
-func deleteReplication(id string) *nerr.E {
+func deleteReplication(ctx context.Context, id string) *nerr.E {
 	l.L.Debugf("Deleting replication %v", id)
 
-	repl, err := getReplication(id)
+	repl, err := getReplication(ctx, id)
 	if err != nil {
 		return err.Addf("Couldn't delete replication %v", id)
 	}
 
-	req, rerr := http.NewRequest("DELETE", fmt.Sprintf("%v/_replicator/%v?rev=%v", COUCH_ADDR, id, repl.Rev), nil)
-	if rerr != nil { //"real" error
-		return nerr.Translate(rerr).Addf("Couldn't delete replication %v", id)
-	}
-
-	req.SetBasicAuth(COUCH_USER, COUCH_PASS)
-	c := http.Client{}
-	resp, rerr := c.Do(req)
+	rerr := localCouch.DeleteDocument(ctx, couchclient.ReplicatorDB, id, repl.Rev)
 	if rerr != nil {
-		return nerr.Translate(rerr).Addf("Couldn't make request to delete replication %v", id)
-	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode/100 == 2 {
-		l.L.Debugf("Replication %v deleted", id)
-		return nil
+		return translateCouchErr(rerr).Addf("Couldn't delete the replication %v", id)
 	}
-	return nerr.Create(fmt.Sprintf("Couldn't delete the replication %v. Status code: %v", id, resp.StatusCode), "failure")
-}
-
-func insertLocalCreds(address string) string {
-	return insertCreds(address, COUCH_USER, COUCH_PASS)
-}
-
-func insertReplCreds(address string) string {
-	return insertCreds(address, COUCH_REPL_USER, COUCH_REPL_PASS)
-}
-
-func insertCreds(address, user, pass string) string {
-	//we need to parse off the http[s]://
-	vals := strings.Split(address, "//")
-	return fmt.Sprintf("%v//%v:%v@%v", vals[0], user, pass, strings.Trim(vals[1], "/"))
 
+	l.L.Debugf("Replication %v deleted", id)
+	return nil
 }
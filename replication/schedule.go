@@ -0,0 +1,204 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/robfig/cron/v3"
+)
+
+//TimeWindow is a daily window of time, from Start until End (both 15:04 formatted). A window whose End is before
+//its Start crosses midnight. If Days (mon, tue, ...) is set, the window only starts on those days.
+type TimeWindow struct {
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Days  []string `json:"days,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+//schedule decides when a replication job runs
+type schedule struct {
+	interval time.Duration
+	cron     cron.Schedule
+	loc      *time.Location
+	quiet    []window
+}
+
+type window struct {
+	start int //minutes into the day
+	end   int
+	days  map[time.Weekday]bool
+}
+
+//newSchedule builds the schedule for a database config. Cron schedules and quiet windows only apply to replications
+//that aren't continuous.
+func newSchedule(config DatabaseConfig) (*schedule, *nerr.E) {
+	s := &schedule{
+		interval: time.Duration(config.Interval) * time.Second,
+		loc:      time.Local,
+	}
+
+	if len(config.Timezone) > 0 {
+		loc, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, nerr.Translate(err).Addf("Invalid timezone %v for %v", config.Timezone, config.Database).SetType("invalid_args")
+		}
+		s.loc = loc
+	}
+
+	if config.Continuous {
+		return s, nil
+	}
+
+	if len(config.Schedule) > 0 {
+		sched, err := cron.ParseStandard(config.Schedule)
+		if err != nil {
+			return nil, nerr.Translate(err).Addf("Invalid schedule %q for %v", config.Schedule, config.Database).SetType("invalid_args")
+		}
+		s.cron = sched
+	}
+
+	for _, tw := range config.QuietWindows {
+		w, err := parseWindow(tw)
+		if err != nil {
+			return nil, err.Addf("Invalid quiet window for %v", config.Database)
+		}
+		s.quiet = append(s.quiet, w)
+	}
+
+	return s, nil
+}
+
+//newJobSchedule builds the schedule for a job, falling back to just the interval if the config is invalid. The
+//interval of a config with a schedule isn't held to the minimum, so it is here.
+func newJobSchedule(config DatabaseConfig) *schedule {
+	s, err := newSchedule(config)
+	if err != nil {
+		interval := config.Interval
+		if interval < cfg.MinInterval {
+			interval = cfg.MinInterval
+		}
+
+		log.L.Error(err.Addf("Falling back to running every %v seconds", interval))
+		return &schedule{
+			interval: time.Duration(interval) * time.Second,
+			loc:      time.Local,
+		}
+	}
+
+	return s
+}
+
+func parseWindow(tw TimeWindow) (window, *nerr.E) {
+	w := window{}
+
+	start, err := time.Parse("15:04", tw.Start)
+	if err != nil {
+		return w, nerr.Translate(err).Addf("Invalid start time %q", tw.Start).SetType("invalid_args")
+	}
+
+	end, err := time.Parse("15:04", tw.End)
+	if err != nil {
+		return w, nerr.Translate(err).Addf("Invalid end time %q", tw.End).SetType("invalid_args")
+	}
+
+	w.start = start.Hour()*60 + start.Minute()
+	w.end = end.Hour()*60 + end.Minute()
+
+	if w.start == w.end {
+		return w, nerr.Create(fmt.Sprintf("Window %v-%v is empty", tw.Start, tw.End), "invalid_args")
+	}
+
+	if len(tw.Days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range tw.Days {
+			key := strings.ToLower(d)
+			if len(key) > 3 {
+				key = key[:3]
+			}
+
+			day, ok := weekdays[key]
+			if !ok {
+				return w, nerr.Create(fmt.Sprintf("Invalid day %q", d), "invalid_args")
+			}
+			w.days[day] = true
+		}
+	}
+
+	return w, nil
+}
+
+//next returns when the job should run next after a successful run at t
+func (s *schedule) next(t time.Time) time.Time {
+	if s.cron != nil {
+		return s.allowed(s.cron.Next(t.In(s.loc)))
+	}
+
+	return s.allowed(t.Add(s.interval))
+}
+
+//allowed returns t, or if t is in a quiet window, the end of that window
+func (s *schedule) allowed(t time.Time) time.Time {
+	//windows can back up against each other, but there can't be more of them in a row than there are windows
+	for i := 0; i <= len(s.quiet); i++ {
+		moved := false
+		for _, w := range s.quiet {
+			if end, ok := w.contains(t.In(s.loc)); ok {
+				t = end
+				moved = true
+			}
+		}
+
+		if !moved {
+			break
+		}
+	}
+
+	return t
+}
+
+//contains reports whether t is in the window, and if so when the window ends
+func (w window) contains(t time.Time) (time.Time, bool) {
+	minutes := t.Hour()*60 + t.Minute()
+	yesterday := t.AddDate(0, 0, -1)
+	tomorrow := t.AddDate(0, 0, 1)
+
+	startsOn := func(day time.Time) bool {
+		return w.days == nil || w.days[day.Weekday()]
+	}
+
+	if w.start < w.end {
+		if minutes >= w.start && minutes < w.end && startsOn(t) {
+			return at(t, w.end), true
+		}
+		return t, false
+	}
+
+	//the window crosses midnight
+	if minutes >= w.start && startsOn(t) {
+		return at(tomorrow, w.end), true
+	}
+
+	if minutes < w.end && startsOn(yesterday) {
+		return at(t, w.end), true
+	}
+
+	return t, false
+}
+
+//at returns the time minutes into the given day
+func at(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location())
+}
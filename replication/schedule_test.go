@@ -0,0 +1,150 @@
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %v isn't available: %v", name, err)
+	}
+
+	return loc
+}
+
+func TestScheduleCron(t *testing.T) {
+	loc := mustLocation(t, "America/Denver")
+
+	s, err := newSchedule(DatabaseConfig{
+		Database: "devices",
+		Schedule: "30 2 * * *",
+		Timezone: "America/Denver",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, loc)
+	want := time.Date(2020, 9, 2, 2, 30, 0, 0, loc)
+	if got := s.next(now); !got.Equal(want) {
+		t.Errorf("got next run %v, want %v", got, want)
+	}
+}
+
+func TestScheduleInterval(t *testing.T) {
+	s, err := newSchedule(DatabaseConfig{Database: "devices", Interval: 300})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	if got := s.next(now); !got.Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("got next run %v, want five minutes later", got)
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DatabaseConfig
+	}{
+		{"cron", DatabaseConfig{Database: "devices", Schedule: "every day"}},
+		{"timezone", DatabaseConfig{Database: "devices", Timezone: "Mars/Olympus_Mons"}},
+		{"window time", DatabaseConfig{Database: "devices", QuietWindows: []TimeWindow{{Start: "25:00", End: "01:00"}}}},
+		{"empty window", DatabaseConfig{Database: "devices", QuietWindows: []TimeWindow{{Start: "01:00", End: "01:00"}}}},
+		{"window day", DatabaseConfig{Database: "devices", QuietWindows: []TimeWindow{{Start: "01:00", End: "02:00", Days: []string{"someday"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newSchedule(tt.config); err == nil {
+				t.Errorf("got no error")
+			} else if err.Type != "invalid_args" {
+				t.Errorf("got error type %v, want invalid_args", err.Type)
+			}
+		})
+	}
+}
+
+func TestQuietWindows(t *testing.T) {
+	loc := mustLocation(t, "America/Denver")
+
+	s, err := newSchedule(DatabaseConfig{
+		Database: "devices",
+		Interval: 60,
+		Timezone: "America/Denver",
+		QuietWindows: []TimeWindow{
+			{Start: "08:00", End: "12:00", Days: []string{"monday", "tue"}},
+			{Start: "12:00", End: "13:00"},
+			{Start: "22:00", End: "06:00"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//2020-09-07 is a monday
+	day := func(d, h, m int) time.Time {
+		return time.Date(2020, 9, d, h, m, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"outside every window", day(7, 7, 0), day(7, 7, 0)},
+		{"in a window", day(7, 9, 0), day(7, 13, 0)},
+		{"at the end of a window", day(7, 13, 0), day(7, 13, 0)},
+		{"on a day the window doesn't start", day(9, 9, 0), day(9, 9, 0)},
+		{"before midnight in a window crossing it", day(7, 23, 0), day(8, 6, 0)},
+		{"after midnight in a window crossing it", day(8, 1, 0), day(8, 6, 0)},
+		{"in a window with no days", day(9, 12, 30), day(9, 13, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.allowed(tt.t); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalFloor(t *testing.T) {
+	min := cfg.MinInterval
+
+	tests := []struct {
+		name   string
+		config DatabaseConfig
+		want   int
+	}{
+		{"too low", DatabaseConfig{Database: "devices", Interval: 1}, min},
+		{"unset", DatabaseConfig{Database: "devices"}, min},
+		{"above the minimum", DatabaseConfig{Database: "devices", Interval: min + 5}, min + 5},
+		{"continuous", DatabaseConfig{Database: "devices", Continuous: true}, 0},
+		{"scheduled", DatabaseConfig{Database: "devices", Schedule: "@hourly"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withIntervalFloor(tt.config).Interval; got != tt.want {
+				t.Errorf("got interval %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobScheduleFallback(t *testing.T) {
+	s := newJobSchedule(DatabaseConfig{Database: "devices", Schedule: "not a schedule"})
+
+	now := time.Now()
+	want := now.Add(time.Duration(cfg.MinInterval) * time.Second)
+	if got := s.next(now); !got.Equal(want) {
+		t.Errorf("got next run %v, want %v from the minimum interval", got, want)
+	}
+}
@@ -1,8 +1,7 @@
 package replication
 
 import (
-	"fmt"
-	"os"
+	"context"
 	"sync"
 	"time"
 
@@ -10,112 +9,264 @@ import (
 	"github.com/byuoitav/common/nerr"
 )
 
-func RunRegular(config DatabaseConfig, configChannel chan DatabaseConfig, wg *sync.WaitGroup) *nerr.E {
+//Shutdown policies, deciding what happens to a job's replication documents when the service stops
+const (
+	ShutdownLeave  = "leave"
+	ShutdownDelete = "delete"
+)
+
+//RunRegular schedules the replication of a database until ctx is cancelled or configChannel is closed
+func RunRegular(ctx context.Context, config DatabaseConfig, configChannel chan DatabaseConfig, wg *sync.WaitGroup) *nerr.E {
 	defer wg.Done()
-	savedInterval := 0
 
-	//there's a 10 second floor
-	if config.Interval < 10 && !config.Continuous {
-		log.L.Infof("Interval of %v is too low, moving to the 10 second minimum", config.Interval)
-		config.Interval = 10
-	}
+	config = withIntervalFloor(config)
 
-	for {
-		log.L.Debugf("Starting replication run for %v", config.Database)
-		//reset retry
-		retry := false
-		if savedInterval != 0 {
-			config.Interval = savedInterval
-			savedInterval = 0
-		}
+	j := trackJob(config)
+	defer untrackJob(j)
 
-		err := ScheduleReplication(config.Database, config.Continuous)
+	retry := newBackoff(config.RetryPolicy())
+	sched := newJobSchedule(config)
 
-		if err != nil {
-			if config.Interval == 0 {
-				savedInterval = config.Interval
-				config.Interval = 60
-			}
-			log.L.Error(err.Addf("Issue scheduling replication for %v. Will try again in %v seconds", config.Database, config.Interval))
-			retry = true
+	//run right away, unless we're in a quiet window
+	next := sched.allowed(time.Now())
+	j.setNextRun(next)
+
+	for {
+		//continuous replications only need to be rescheduled if they failed, or if their config changes
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			timerC = timer.C
 		}
 
-		log.L.Debugf("Done for %v. Will run again in %v seconds", config.Database, config.Interval)
-		if config.Continuous && !retry {
-			newConf, closed := <-configChannel
-			config = newConf
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			shutdownReplication(config)
+			return nil
+		case newConf, closed := <-configChannel:
+			stopTimer(timer)
 			if !closed {
 				log.L.Warnf("Replication for %v is ending", config.Database)
-				deleteReplication(fmt.Sprintf("auto_%v", config.Database)) // nolint:errcheck
+				stopReplication(ctx, config, DatabaseConfig{})
 
 				return nil
 			}
-		} else {
-			//start a timer
-			t := time.NewTimer(time.Duration(config.Interval) * time.Second)
-			select {
-			case newConf, closed := <-configChannel:
-				if !closed {
-					log.L.Warnf("Replication for %v is ending", config.Database)
-					deleteReplication(fmt.Sprintf("auto_%v", config.Database)) // nolint:errcheck
+			stopReplication(ctx, config, newConf)
 
-					return nil
-				}
-				config = newConf
-				break
-			case <-t.C:
-				break
+			config = withIntervalFloor(newConf)
+			j.setConfig(config)
+			retry = newBackoff(config.RetryPolicy())
+			sched = newJobSchedule(config)
+
+			next = sched.allowed(time.Now())
+			j.setNextRun(next)
+			continue
+		case <-sourceChanged():
+			stopTimer(timer)
+
+			//continuous replications need to move to the new source now, the rest can wait until they next run
+			if config.Continuous {
+				next = time.Now()
+			}
+			continue
+		case <-credentialsChanged():
+			stopTimer(timer)
+
+			//the same goes for new credentials
+			if config.Continuous {
+				next = time.Now()
+			}
+			continue
+		case <-timerC:
+		}
+
+		log.L.Debugf("Starting replication run for %v", config.Database)
+
+		//the backoff only starts over once the last run is seen to have worked
+		succeeded, lastFailed := previousRun(ctx, config)
+		if succeeded {
+			retry.reset()
+		}
+
+		err := ScheduleReplication(ctx, config)
+
+		//a replication that's still running from last time isn't a failure
+		failed := err != nil && err.Type != "duplicate_repl"
+		now := time.Now()
+
+		switch {
+		case failed:
+			next = sched.allowed(now.Add(retry.next()))
+			log.L.Error(err.Addf("Issue scheduling replication for %v. Will try again at %v", config.Database, next.Format(time.RFC3339)))
+		case config.Continuous && err != nil && succeeded:
+			next = time.Time{}
+			log.L.Debugf("Done for %v. Waiting for config changes", config.Database)
+		case config.Continuous:
+			//check back until it's running, replacing it again if it fails
+			next = sched.allowed(now.Add(retry.next()))
+			log.L.Debugf("Scheduled %v. Will check that it's running at %v", config.Database, next.Format(time.RFC3339))
+		case lastFailed:
+			next = sched.next(now)
+			if retryAt := now.Add(retry.next()); retryAt.After(next) {
+				next = sched.allowed(retryAt)
 			}
+			log.L.Warnf("The last replication of %v failed. Will run again at %v", config.Database, next.Format(time.RFC3339))
+		default:
+			next = sched.next(now)
+			log.L.Debugf("Done for %v. Will run again at %v", config.Database, next.Format(time.RFC3339))
+		}
+
+		j.setNextRun(next)
+	}
+}
+
+//previousRun checks on the replication documents posted by the last run. It reports whether every one of them
+//completed (or is running, for continuous replications), and whether any of them failed.
+func previousRun(ctx context.Context, config DatabaseConfig) (succeeded, failed bool) {
+	succeeded = true
+	for _, id := range config.ReplicationIDs() {
+		state, err := getReplicationState(ctx, id)
+		if err != nil {
+			return false, false
+		}
+
+		switch {
+		case state.State == "completed", config.Continuous && state.State == "running":
+		case failedState(state.State):
+			succeeded, failed = false, true
+		default:
+			succeeded = false
 		}
 	}
+
+	return succeeded, failed
 }
 
-func RunConfig(curConfig HostConfig, config DatabaseConfig, wg *sync.WaitGroup, configChannels map[string]chan DatabaseConfig) *nerr.E {
+//withIntervalFloor applies the minimum interval to replications that aren't continuous
+func withIntervalFloor(config DatabaseConfig) DatabaseConfig {
+	if config.Interval < cfg.MinInterval && !config.Continuous && len(config.Schedule) == 0 {
+		log.L.Infof("Interval of %v is too low, moving to the %v second minimum", config.Interval, cfg.MinInterval)
+		config.Interval = cfg.MinInterval
+	}
+
+	return config
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+//stopReplication deletes the replication documents for any leg of the old config that isn't part of the new config.
+//If the databases or server being replicated, or which documents are replicated, changed, every leg is deleted so
+//they can be replaced.
+func stopReplication(ctx context.Context, oldConf, newConf DatabaseConfig) {
+	keep := make(map[string]bool)
+	if sameEndpoints(oldConf, newConf) && sameDocuments(oldConf, newConf) {
+		for _, id := range newConf.ReplicationIDs() {
+			keep[id] = true
+		}
+	}
+
+	directions, _ := oldConf.Directions()
+	for _, direction := range directions {
+		id := ReplicationID(oldConf.Database, direction)
+		if keep[id] {
+			continue
+		}
+
+		log.L.Infof("Removing replication %v", id)
+		recordReplaced(ctx, oldConf, direction)
+		deleteReplication(ctx, id) // nolint:errcheck
+	}
+}
+
+//shutdownReplication applies the shutdown policy to a job's replication documents once the service is stopping
+func shutdownReplication(config DatabaseConfig) {
+	if cfg.ShutdownPolicy != ShutdownDelete {
+		log.L.Infof("Leaving replication documents for %v in place", config.Database)
+		return
+	}
+
+	//the job's context is already done, so the documents are deleted with a fresh one
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stopReplication(ctx, config, DatabaseConfig{})
+}
+
+//RunConfig keeps the replication-config database replicated and updates the other jobs as the config changes,
+//until ctx is cancelled
+func RunConfig(ctx context.Context, curConfig HostConfig, config DatabaseConfig, wg *sync.WaitGroup, configChannels map[string]chan DatabaseConfig) *nerr.E {
 
 	log.L.Infof("Running config for %s", config.Database)
-	savedInterval := 0
 
 	defer wg.Done()
 	if config.Database != REPL_CONFIG_DB {
 		return nerr.Create("Can't start a config DB not replicating the REPL_CONFIG_DB database", "invalid_args")
 	}
 
-	//there's a 10 second floor
-	if config.Interval < 10 && !config.Continuous {
-		log.L.Infof("Interval of %v is too low, moving to the 10 second minimum", config.Interval)
+	//there's a minimum interval. Even continuous replications of the config need to be checked for changes.
+	if config.Interval < cfg.MinInterval {
+		log.L.Infof("Interval of %v is too low, moving to the %v second minimum", config.Interval, cfg.MinInterval)
+	}
+
+	config = withConfigWatch(config)
+
+	//a config that doesn't come from the config database isn't replicated, so there's no job to report or verify
+	j := &job{config: config}
+	if replicatingConfig() {
+		j = trackJob(config)
+		defer untrackJob(j)
+	}
+
+	retry := newBackoff(config.RetryPolicy())
+
+	//nothing is ever sent on changed unless we're watching the changes feed
+	changed := make(chan struct{}, 1)
+	if watchingChanges() {
+		go watchConfig(ctx, cfg.Hostname, changed)
 	}
 
 	for {
 
 		log.L.Debugf("Starting a run for %v", config.Database)
-		if savedInterval != 0 {
-			config.Interval = savedInterval
-			savedInterval = 0
+
+		//a config that doesn't come from the config database only needs to be checked for changes
+		var err *nerr.E
+		if replicatingConfig() {
+			err = ScheduleReplication(ctx, config)
 		}
+		if err != nil && err.Type != "duplicate_repl" {
+			setState(StateDegraded, "unable to replicate "+REPL_CONFIG_DB+": "+err.Error())
 
-		err := ScheduleReplication(REPL_CONFIG_DB, config.Continuous)
-		if err != nil {
-			if config.Interval == 0 {
-				savedInterval = config.Interval
-				config.Interval = 60
-			}
-			log.L.Error(err.Addf("Issue scheduling replication for %v. Will try again in %v seconds", config.Database, config.Interval))
+			wait := retry.next()
+			log.L.Error(err.Addf("Issue scheduling replication for %v. Will try again in %v", config.Database, wait))
 
-			time.Sleep(time.Duration(config.Interval) * time.Second)
+			j.setNextRun(time.Now().Add(wait))
+			if !waitForConfig(ctx, wait, changed) {
+				shutdownReplication(config)
+				return nil
+			}
 			continue
 		}
 
+		setState(StateRunning, "")
+		wait := configInterval(config)
+
 		//we need to get our configuration
-		newGlobalConf, err := GetConfig(os.Getenv("SYSTEM_ID"))
+		newGlobalConf, err := GetConfig(ctx, cfg.Hostname)
 
 		if err != nil {
 			//if this gets triggered it means someone deleted both the default and room specific configuration for this room.
-			log.L.Errorf("Couldn't get the configuration for %v", os.Getenv("SYSTEM_ID"))
-			if config.Interval == 0 {
-				savedInterval = config.Interval
-				config.Interval = 60
-			}
+			wait = retry.next()
+			log.L.Errorf("Couldn't get the configuration for %v. Will try again in %v", cfg.Hostname, wait)
 		} else {
+			retry.reset()
+
 			changes := true
 
 			//we need to check if the configurations are equal
@@ -128,9 +279,11 @@ func RunConfig(curConfig HostConfig, config DatabaseConfig, wg *sync.WaitGroup,
 				log.L.Debugf("%v === %v", newGlobalConf, curConfig)
 
 				curConfig = newGlobalConf
+				configReloads.Inc()
+				publishConfigReload(curConfig)
 
 				//this will redo everyone else
-				UpdateConfigurations(curConfig, configChannels, wg)
+				UpdateConfigurations(ctx, curConfig, configChannels, wg)
 
 				found := false
 				//we need to go through and update our configuration
@@ -142,23 +295,53 @@ func RunConfig(curConfig HostConfig, config DatabaseConfig, wg *sync.WaitGroup,
 					}
 				}
 
+				if !found {
+					//we need to grab the default config
+					config = DefaultReplConfig
+				}
+				config = withConfigWatch(config)
+
+				j.setConfig(config)
+				retry = newBackoff(config.RetryPolicy())
+
 				if found {
 					continue
 				}
 
-				//we need to grab the default config
-				config = DefaultReplConfig
+				wait = configInterval(config)
 			}
 		}
 
 		//start a timer
-		log.L.Debugf("Done for %v. Will run again in %v seconds", config.Database, config.Interval)
+		log.L.Debugf("Done for %v. Will run again in %v", config.Database, wait)
+		j.setNextRun(time.Now().Add(wait))
 
-		time.Sleep(time.Duration(config.Interval) * time.Second)
+		if !waitForConfig(ctx, wait, changed) {
+			shutdownReplication(config)
+			return nil
+		}
 	}
 }
 
-func UpdateConfigurations(config HostConfig, channels map[string]chan DatabaseConfig, wg *sync.WaitGroup) {
+//withConfigWatch makes the config database replicate continuously when we're following its changes feed
+func withConfigWatch(config DatabaseConfig) DatabaseConfig {
+	if watchingChanges() {
+		config.Continuous = true
+	}
+
+	return config
+}
+
+//configInterval is how often the config database is checked, with the minimum interval applied
+func configInterval(config DatabaseConfig) time.Duration {
+	if config.Interval < cfg.MinInterval {
+		return cfg.MinIntervalDuration()
+	}
+
+	return time.Duration(config.Interval) * time.Second
+}
+
+func UpdateConfigurations(ctx context.Context, config HostConfig, channels map[string]chan DatabaseConfig, wg *sync.WaitGroup) {
 	log.L.Infof("Updating configurations")
 	valsInConfig := make(map[string]bool)
 	for _, c := range config.Replications {
@@ -170,7 +353,12 @@ func UpdateConfigurations(config HostConfig, channels map[string]chan DatabaseCo
 		//we go through and update/create as needed
 		v, ok := channels[c.Database]
 		if ok {
-			v <- c
+			//the job may have already stopped if we're shutting down
+			select {
+			case v <- c:
+			case <-ctx.Done():
+				return
+			}
 			continue
 		} else {
 			log.L.Infof("Creating replication job for %v", c.Database)
@@ -179,7 +367,7 @@ func UpdateConfigurations(config HostConfig, channels map[string]chan DatabaseCo
 
 			//create a channel
 			newChan := make(chan DatabaseConfig, 1)
-			go RunRegular(c, newChan, wg) // nolint:errcheck
+			go RunRegular(ctx, c, newChan, wg) // nolint:errcheck
 			channels[c.Database] = newChan
 		}
 	}
@@ -197,21 +385,28 @@ func UpdateConfigurations(config HostConfig, channels map[string]chan DatabaseCo
 	log.L.Infof("Done.")
 }
 
-func StartReplicationJobs(config HostConfig) *nerr.E {
+//StartReplicationJobs starts a job for each database in config, and blocks until every job has stopped after
+//ctx is cancelled
+func StartReplicationJobs(ctx context.Context, config HostConfig) *nerr.E {
+	schedulerRunning(true)
+	defer schedulerRunning(false)
+
 	wg := &sync.WaitGroup{}
 	channelMap := make(map[string]chan DatabaseConfig)
-	UpdateConfigurations(config, channelMap, wg)
+	UpdateConfigurations(ctx, config, channelMap, wg)
 
 	found := false
 	for _, c := range config.Replications {
 		if c.Database == REPL_CONFIG_DB {
 			found = true
-			go RunConfig(config, c, wg, channelMap) // nolint:errcheck
+			wg.Add(1)
+			go RunConfig(ctx, config, c, wg, channelMap) // nolint:errcheck
 		}
 	}
 	if !found {
 		//run using the default
-		go RunConfig(config, DefaultReplConfig, wg, channelMap) // nolint:errcheck
+		wg.Add(1)
+		go RunConfig(ctx, config, DefaultReplConfig, wg, channelMap) // nolint:errcheck
 	}
 
 	wg.Wait()
@@ -0,0 +1,148 @@
+package replication
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//defaultSelectors are used for databases that don't have a selector or filter in their config
+var defaultSelectors = map[string]map[string]interface{}{
+	"devices": {
+		"_id": map[string]interface{}{
+			"$regex": "{{building}}-{{room}}-",
+		},
+	},
+}
+
+//templateVars builds the values available to selectors and query params for a hostname. If the hostname can't be
+//parsed, only {{hostname}} is available and the error is returned.
+func templateVars(hostname string) (map[string]string, *nerr.E) {
+	vars := map[string]string{
+		"hostname": hostname,
+	}
+
+	host, err := ParseHostname(hostname)
+	if err != nil {
+		return vars, err
+	}
+
+	vars["building"] = host.Building
+	vars["room"] = host.Room
+	vars["device"] = host.Device
+
+	return vars, nil
+}
+
+//usesHostVars reports whether any string in a decoded json value uses one of the template variables parsed out of
+//the hostname
+func usesHostVars(v interface{}) bool {
+	switch val := v.(type) {
+	case string:
+		for _, name := range []string{"building", "room", "device"} {
+			if strings.Contains(val, fmt.Sprintf("{{%v}}", name)) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for k := range val {
+			if usesHostVars(val[k]) {
+				return true
+			}
+		}
+	case []interface{}:
+		for i := range val {
+			if usesHostVars(val[i]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func expandTemplate(s string, vars map[string]string) string {
+	for k, v := range vars {
+		s = strings.ReplaceAll(s, fmt.Sprintf("{{%v}}", k), v)
+	}
+
+	return s
+}
+
+//quoteVars escapes the values of vars so they only match themselves in a regex
+func quoteVars(vars map[string]string) map[string]string {
+	toReturn := make(map[string]string, len(vars))
+	for k, v := range vars {
+		toReturn[k] = regexp.QuoteMeta(v)
+	}
+
+	return toReturn
+}
+
+//expandSelector walks a decoded json value and expands the template variables in every string in it. Values expanded
+//in a $regex are escaped, so a hostname like ITB-1101.2 only matches itself.
+func expandSelector(v interface{}, vars map[string]string) interface{} {
+	switch val := v.(type) {
+	case string:
+		return expandTemplate(val, vars)
+	case map[string]interface{}:
+		toReturn := make(map[string]interface{}, len(val))
+		for k := range val {
+			if s, ok := val[k].(string); ok && k == "$regex" {
+				toReturn[k] = expandTemplate(s, quoteVars(vars))
+				continue
+			}
+
+			toReturn[k] = expandSelector(val[k], vars)
+		}
+		return toReturn
+	case []interface{}:
+		toReturn := make([]interface{}, len(val))
+		for i := range val {
+			toReturn[i] = expandSelector(val[i], vars)
+		}
+		return toReturn
+	default:
+		return v
+	}
+}
+
+//applyFilter sets the selector or filter on a replication document based on the database config
+func applyFilter(rdoc *couchclient.ReplicationDoc, config DatabaseConfig, hostname string) *nerr.E {
+	if config.Selector != nil && len(config.Filter) > 0 {
+		return nerr.Create(fmt.Sprintf("Config for %v has both a selector and a filter, only one may be used", config.Database), "invalid_args")
+	}
+
+	vars, verr := templateVars(hostname)
+
+	if len(config.Filter) > 0 {
+		rdoc.Filter = config.Filter
+		if len(config.QueryParams) > 0 {
+			rdoc.QueryParams = make(map[string]string, len(config.QueryParams))
+			for k, v := range config.QueryParams {
+				if verr != nil && usesHostVars(v) {
+					return verr.Addf("Couldn't build query param %v for %v", k, config.Database)
+				}
+				rdoc.QueryParams[k] = expandTemplate(v, vars)
+			}
+		}
+		return nil
+	}
+
+	selector := config.Selector
+	if selector == nil {
+		selector = defaultSelectors[config.Database]
+	}
+
+	if selector != nil {
+		if verr != nil && usesHostVars(selector) {
+			return verr.Addf("Couldn't build the selector for %v", config.Database)
+		}
+		rdoc.Selector = expandSelector(selector, vars)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package replication
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+func TestApplyFilterEscapesRegexValues(t *testing.T) {
+	config := DatabaseConfig{
+		Database: "devices",
+		Selector: map[string]interface{}{
+			"_id":      map[string]interface{}{"$regex": "^{{building}}-{{room}}-"},
+			"hostname": map[string]interface{}{"$eq": "{{hostname}}"},
+			"$or": []interface{}{
+				map[string]interface{}{"room": map[string]interface{}{"$regex": "{{room}}$"}},
+			},
+		},
+	}
+
+	var rdoc couchclient.ReplicationDoc
+	if err := applyFilter(&rdoc, config, "ITB-11.1+A-CP1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"_id":      map[string]interface{}{"$regex": `^ITB-11\.1\+A-`},
+		"hostname": map[string]interface{}{"$eq": "ITB-11.1+A-CP1"},
+		"$or": []interface{}{
+			map[string]interface{}{"room": map[string]interface{}{"$regex": `11\.1\+A$`}},
+		},
+	}
+
+	if !reflect.DeepEqual(rdoc.Selector, want) {
+		t.Fatalf("got selector %v, want %v", rdoc.Selector, want)
+	}
+
+	re := regexp.MustCompile(want["_id"].(map[string]interface{})["$regex"].(string))
+	if !re.MatchString("ITB-11.1+A-CP2") || re.MatchString("ITB-1101A-CP2") {
+		t.Errorf("regex %v should only match documents in the room itself", re)
+	}
+}
+
+func TestApplyFilterQueryParams(t *testing.T) {
+	config := DatabaseConfig{
+		Database:    "devices",
+		Filter:      "filters/room",
+		QueryParams: map[string]string{"room": "{{building}}-{{room}}"},
+	}
+
+	var rdoc couchclient.ReplicationDoc
+	if err := applyFilter(&rdoc, config, "ITB-11.1-CP1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//query params go to the filter as they are
+	if rdoc.Filter != "filters/room" || rdoc.QueryParams["room"] != "ITB-11.1" {
+		t.Errorf("got filter %v with query params %v", rdoc.Filter, rdoc.QueryParams)
+	}
+}
@@ -0,0 +1,66 @@
+package replication
+
+import (
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//cfg are the settings the package was configured with
+var cfg = settings.Default()
+
+//Configure sets up the package with s. It must be called before Init.
+func Configure(s settings.Settings) *nerr.E {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	cfg = s
+
+	var remoteOpts []couchclient.Option
+	if tlsOpts := remoteTLSOptions(); tlsOpts.Enabled() {
+		tlsConfig, err := couchclient.NewTLSConfig(tlsOpts)
+		if err != nil {
+			return nerr.Translate(err).Add("Couldn't set up TLS for the remote couch server").SetType("invalid_args")
+		}
+
+		remoteOpts = append(remoteOpts, couchclient.WithTLSConfig(tlsConfig))
+	}
+
+	localCouch = couchclient.New(s.CouchAddr, s.CouchUser, s.CouchPass)
+
+	newSources := func(list []settings.Source) []*source {
+		var toReturn []*source
+		for _, src := range list {
+			toReturn = append(toReturn, &source{
+				name:   src.Name,
+				client: couchclient.New(src.Addr, s.RemoteUser, s.RemotePass, remoteOpts...),
+			})
+		}
+		return toReturn
+	}
+	setSources(newSources(s.Sources()), newSources(s.Aliases()))
+
+	if err := loadCredentials(); err != nil {
+		return err
+	}
+
+	if err := initHostnameParser(); err != nil {
+		return err
+	}
+
+	if err := initConfigProvider(); err != nil {
+		return err
+	}
+
+	if err := initEvents(); err != nil {
+		return err
+	}
+
+	//setup the default config
+	DefaultReplConfig = DatabaseConfig{
+		Database: REPL_CONFIG_DB,
+		Interval: s.DefaultInterval,
+	}
+
+	return nil
+}
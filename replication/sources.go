@@ -0,0 +1,425 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//while running, a source has to pass or fail this many checks in a row before its health changes, so a blip doesn't
+//cause a failover
+const healthThreshold = 2
+
+//source is a remote couch server that can be replicated from
+type source struct {
+	name   string
+	client *couchclient.Client
+
+	healthy   bool
+	streak    int //checks in a row that disagreed with healthy
+	lastCheck time.Time
+	lastErr   string
+}
+
+//SourceStatus is the health of a remote couch server
+type SourceStatus struct {
+	Name      string     `json:"name"`
+	Addr      string     `json:"addr"`
+	Healthy   bool       `json:"healthy"`
+	Active    bool       `json:"active"`
+	Alias     bool       `json:"alias,omitempty"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+var sources = struct {
+	sync.RWMutex
+	list    []*source
+	aliases []*source
+	active  *source
+	changed chan struct{}
+}{changed: make(chan struct{})}
+
+//setSources replaces the remote servers, in order of preference, and the aliases that are only used by databases
+//that name them. The sources all start out healthy, and the aliases are never checked.
+func setSources(list, aliases []*source) {
+	sources.Lock()
+	defer sources.Unlock()
+
+	for _, s := range list {
+		s.healthy = true
+	}
+
+	sources.list = list
+	sources.aliases = aliases
+	sources.active = nil
+	if len(list) > 0 {
+		sources.active = list[0]
+	}
+}
+
+//activeSource is the remote server replications should be pulled from, which is the first healthy one. If none of
+//them are healthy it's the first one.
+func activeSource() *source {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	return sources.active
+}
+
+//activeClient is the client for the active source
+func activeClient() *couchclient.Client {
+	if s := activeSource(); s != nil {
+		return s.client
+	}
+
+	//there's no remote server configured, so anything using it will fail
+	return couchclient.New("", "", "")
+}
+
+//sourceClients are the clients for every source
+func sourceClients() []*couchclient.Client {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	clients := make([]*couchclient.Client, 0, len(sources.list)+len(sources.aliases))
+	for _, s := range append(sources.list, sources.aliases...) {
+		clients = append(clients, s.client)
+	}
+
+	return clients
+}
+
+//replicationSource is the source a database should be replicated with, either the one named in its config or the
+//active source
+func replicationSource(config DatabaseConfig) (*source, *nerr.E) {
+	if len(config.SourceServer) == 0 {
+		if s := activeSource(); s != nil {
+			return s, nil
+		}
+
+		return nil, nerr.Create("There's no remote couch server to use", "invalid_args")
+	}
+
+	sources.RLock()
+	defer sources.RUnlock()
+
+	for _, s := range append(sources.list, sources.aliases...) {
+		if s.name == config.SourceServer {
+			return s, nil
+		}
+	}
+
+	return nil, nerr.Create(fmt.Sprintf("There's no remote source or alias named %v", config.SourceServer), "invalid_args")
+}
+
+//sourceChanged returns a channel that's closed the next time the active source changes
+func sourceChanged() <-chan struct{} {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	return sources.changed
+}
+
+//checkSources pings each source, updates their health once it's been the same for threshold checks in a row, and
+//fails over or back if the active source changes
+func checkSources(ctx context.Context, threshold int) {
+	sources.RLock()
+	list := sources.list
+	sources.RUnlock()
+
+	for _, s := range list {
+		err := s.client.Ping(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		ok := err == nil || couchclient.IsUnauthorized(err)
+
+		sources.Lock()
+		s.lastCheck = time.Now()
+		s.lastErr = ""
+		if !ok {
+			s.lastErr = err.Error()
+		}
+
+		if ok == s.healthy {
+			s.streak = 0
+		} else {
+			s.streak++
+			if s.streak >= threshold {
+				s.healthy = ok
+				s.streak = 0
+
+				if ok {
+					l.L.Infof("Remote source %v is healthy again", s.name)
+				} else {
+					l.L.Warnf("Remote source %v is unhealthy: %v", s.name, s.lastErr)
+				}
+			}
+		}
+		sources.Unlock()
+	}
+
+	sources.Lock()
+	defer sources.Unlock()
+
+	next := sources.active
+	for i, s := range sources.list {
+		if s.healthy {
+			next = s
+			break
+		}
+
+		if i == len(sources.list)-1 {
+			next = sources.list[0]
+		}
+	}
+
+	if next == sources.active {
+		return
+	}
+
+	l.L.Warnf("Switching remote source from %v to %v", sources.active.name, next.name)
+	sources.active = next
+	close(sources.changed)
+	sources.changed = make(chan struct{})
+}
+
+//watchSources checks the health of the sources until ctx is cancelled
+func watchSources(ctx context.Context) {
+	t := time.NewTicker(time.Duration(cfg.SourceCheckInterval) * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		checkSources(ctx, healthThreshold)
+	}
+}
+
+//GetSources returns the health of each remote source in order of preference, followed by the aliases
+func GetSources() []SourceStatus {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	toReturn := make([]SourceStatus, 0, len(sources.list)+len(sources.aliases))
+	for _, s := range sources.list {
+		status := SourceStatus{
+			Name:    s.name,
+			Addr:    s.client.Addr(),
+			Healthy: s.healthy,
+			Active:  s == sources.active,
+			Error:   s.lastErr,
+		}
+
+		if !s.lastCheck.IsZero() {
+			t := s.lastCheck
+			status.LastCheck = &t
+		}
+
+		toReturn = append(toReturn, status)
+	}
+
+	for _, s := range sources.aliases {
+		toReturn = append(toReturn, SourceStatus{
+			Name:  s.name,
+			Addr:  s.client.Addr(),
+			Alias: true,
+		})
+	}
+
+	return toReturn
+}
+
+//posted is the source each replication was last posted with, for replications that are known to be using the current
+//credentials. It's cleared when the credentials are reloaded.
+var posted = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+func markPosted(replID, source string) {
+	posted.Lock()
+	defer posted.Unlock()
+
+	posted.m[replID] = source
+}
+
+func forgetPosted() {
+	posted.Lock()
+	defer posted.Unlock()
+
+	posted.m = make(map[string]string)
+}
+
+//postedSource is the source a replication is known to be using
+func postedSource(replID string) string {
+	posted.Lock()
+	defer posted.Unlock()
+
+	return posted.m[replID]
+}
+
+//restorePosted works out the source each replication document from before a restart was posted with, so they're
+//reported with their source and failed over like the rest. Documents whose credentials aren't given the way they
+//would be now are left out, so they're still checked the next time they're scheduled.
+func restorePosted(ctx context.Context) *nerr.E {
+	var docs []couchclient.ReplicationDoc
+	err := localCouch.AllDocs(ctx, couchclient.ReplicatorDB, couchclient.AllDocsOptions{
+		StartKey: "auto_",
+		EndKey:   "auto_\ufff0",
+	}, &docs)
+	if err != nil {
+		return translateCouchErr(err).Addf("Couldn't list the replication documents")
+	}
+
+	sources.RLock()
+	all := make([]*source, 0, len(sources.list)+len(sources.aliases))
+	all = append(append(all, sources.list...), sources.aliases...)
+	sources.RUnlock()
+
+	for _, doc := range docs {
+		if src := postedWith(doc, all); src != nil {
+			markPosted(doc.ID, src.name)
+		}
+	}
+
+	return nil
+}
+
+//postedWith is the one of srcs that doc replicates with, if its credentials are given the way they would be now
+func postedWith(doc couchclient.ReplicationDoc, srcs []*source) *source {
+	for _, src := range srcs {
+		//pulls replicate from the remote server, and pushes replicate to it
+		remote, local := doc.Source, doc.Target
+		if !strings.HasPrefix(remote.URL, src.client.Addr()+"/") {
+			remote, local = doc.Target, doc.Source
+		}
+
+		if !strings.HasPrefix(remote.URL, src.client.Addr()+"/") {
+			continue
+		}
+
+		if !sameAuth(remote, endpoint(src.client, "")) || !sameAuth(local, endpoint(localCouch, "")) {
+			return nil
+		}
+
+		return src
+	}
+
+	return nil
+}
+
+//sameAuth reports whether a and b give their credentials the same way
+func sameAuth(a, b couchclient.Endpoint) bool {
+	return !a.HasEmbeddedCredentials() && !b.HasEmbeddedCredentials() &&
+		(a.Auth == nil) == (b.Auth == nil) && (len(a.Headers) == 0) == (len(b.Headers) == 0)
+}
+
+//replicationStale reports whether a running replication needs to be replaced by rdoc, and why. It does if its
+//credentials are embedded in its urls or given differently than rdoc gives them, if the credentials were reloaded after it started, if it isn't replicating
+//between the same databases with src, or if it's replicating different documents than rdoc would.
+func replicationStale(ctx context.Context, rdoc couchclient.ReplicationDoc, src *source) (bool, string) {
+	name := postedSource(rdoc.ID)
+	if len(name) > 0 && name != src.name {
+		return true, fmt.Sprintf("it's using %v instead of %v", name, src.name)
+	}
+
+	doc, err := getReplication(ctx, rdoc.ID)
+	if err != nil {
+		//we can't tell, so leave it alone
+		return false, ""
+	}
+
+	//couch won't change what a running replication does, so it has to be replaced
+	if reason := settingsChanged(doc, rdoc); len(reason) > 0 {
+		return true, reason
+	}
+
+	if len(name) > 0 {
+		return false, ""
+	}
+
+	if doc.Source.HasEmbeddedCredentials() || doc.Target.HasEmbeddedCredentials() {
+		return true, "its credentials are in its urls"
+	}
+
+	if !sameAuth(doc.Source, rdoc.Source) || !sameAuth(doc.Target, rdoc.Target) {
+		return true, fmt.Sprintf("its credentials aren't given with %v", replicationAuth)
+	}
+
+	if doc.Source.URL != rdoc.Source.URL || doc.Target.URL != rdoc.Target.URL {
+		return true, fmt.Sprintf("it isn't replicating %v to %v", rdoc.Source.URL, rdoc.Target.URL)
+	}
+
+	if changed := credentialsChangedAt(); !changed.IsZero() {
+		state, err := getReplicationState(ctx, rdoc.ID)
+		if err == nil && state.StartTime.Before(changed) {
+			return true, "its credentials are out of date"
+		}
+	}
+
+	markPosted(rdoc.ID, src.name)
+	return false, ""
+}
+
+//settingsChanged reports how the documents replicated by doc differ from the ones rdoc would replicate, if they do
+func settingsChanged(doc, rdoc couchclient.ReplicationDoc) string {
+	switch {
+	case doc.Continuous != rdoc.Continuous:
+		return fmt.Sprintf("continuous changed to %v", rdoc.Continuous)
+	case doc.Filter != rdoc.Filter:
+		return fmt.Sprintf("its filter changed to %q", rdoc.Filter)
+	case !sameJSON(doc.QueryParams, rdoc.QueryParams):
+		return "its query params changed"
+	case !sameJSON(doc.Selector, rdoc.Selector):
+		return "its selector changed"
+	}
+
+	return ""
+}
+
+//sameJSON reports whether a and b are the same once they're encoded, so a value read back from couch matches the one
+//that was posted. Empty maps are the same as no map, since they're left out of the posted document.
+func sameJSON(a, b interface{}) bool {
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	var av, bv interface{}
+	if err := json.Unmarshal(aj, &av); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(bj, &bv); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(emptyToNil(av), emptyToNil(bv))
+}
+
+func emptyToNil(v interface{}) interface{} {
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 0 {
+		return nil
+	}
+
+	return v
+}
@@ -0,0 +1,255 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+//fakeSource is a remote couch server whose health can be changed. A status of 0 means it's up.
+type fakeSource struct {
+	*httptest.Server
+	status int32
+}
+
+func newFakeSource(t *testing.T) *fakeSource {
+	t.Helper()
+
+	f := &fakeSource{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := atomic.LoadInt32(&f.status); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		w.Write([]byte(`{"couchdb":"Welcome"}`)) // nolint:errcheck
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeSource) set(status int) {
+	atomic.StoreInt32(&f.status, int32(status))
+}
+
+//useSources replicates from srcs, in order, for the rest of the test
+func useSources(t *testing.T, names []string, srcs ...*fakeSource) {
+	t.Helper()
+
+	list := make([]*source, 0, len(srcs))
+	for i, f := range srcs {
+		list = append(list, &source{name: names[i], client: couchclient.New(f.URL, "", "")})
+	}
+
+	setSources(list, nil)
+	t.Cleanup(func() {
+		setSources(nil, nil)
+	})
+}
+
+func activeName() string {
+	if s := activeSource(); s != nil {
+		return s.name
+	}
+
+	return ""
+}
+
+func changedSince(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := newFakeSource(t), newFakeSource(t)
+	useSources(t, []string{"primary", "secondary"}, primary, secondary)
+
+	ctx := context.Background()
+
+	checkSources(ctx, healthThreshold)
+	if activeName() != "primary" {
+		t.Fatalf("got active source %v, want primary", activeName())
+	}
+
+	//a single failed check is a blip
+	changed := sourceChanged()
+	primary.set(http.StatusServiceUnavailable)
+	checkSources(ctx, healthThreshold)
+	if activeName() != "primary" || changedSince(changed) {
+		t.Errorf("failed over to %v after one failed check", activeName())
+	}
+
+	checkSources(ctx, healthThreshold)
+	if activeName() != "secondary" {
+		t.Errorf("got active source %v, want secondary after the primary failed twice", activeName())
+	}
+	if !changedSince(changed) {
+		t.Errorf("the source changed without telling anyone")
+	}
+
+	//a source that needs credentials is up
+	changed = sourceChanged()
+	primary.set(http.StatusUnauthorized)
+	checkSources(ctx, healthThreshold)
+	checkSources(ctx, healthThreshold)
+	if activeName() != "primary" {
+		t.Errorf("got active source %v, want to fail back to primary", activeName())
+	}
+	if !changedSince(changed) {
+		t.Errorf("the source changed without telling anyone")
+	}
+
+	statuses := GetSources()
+	if len(statuses) != 2 || !statuses[0].Active || !statuses[0].Healthy || statuses[0].LastCheck == nil {
+		t.Errorf("got %+v, want the primary healthy and active", statuses)
+	}
+}
+
+func TestFailoverWithNothingHealthy(t *testing.T) {
+	primary, secondary := newFakeSource(t), newFakeSource(t)
+	useSources(t, []string{"primary", "secondary"}, primary, secondary)
+
+	ctx := context.Background()
+
+	secondary.set(http.StatusServiceUnavailable)
+	checkSources(ctx, 1)
+
+	primary.set(http.StatusServiceUnavailable)
+	checkSources(ctx, 1)
+
+	if activeName() != "primary" {
+		t.Errorf("got active source %v, want the first source when none are healthy", activeName())
+	}
+
+	for _, s := range GetSources() {
+		if s.Healthy || len(s.Error) == 0 {
+			t.Errorf("got %+v, want it unhealthy with an error", s)
+		}
+	}
+}
+
+func TestReplicationSource(t *testing.T) {
+	primary, secondary := newFakeSource(t), newFakeSource(t)
+	useSources(t, []string{"primary", "secondary"}, primary, secondary)
+
+	tests := []struct {
+		server string
+		want   string
+		err    bool
+	}{
+		{server: "", want: "primary"},
+		{server: "secondary", want: "secondary"},
+		{server: "missing", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			src, err := replicationSource(DatabaseConfig{Database: "devices", SourceServer: tt.server})
+			switch {
+			case tt.err && err == nil:
+				t.Errorf("got source %v, want an error", src.name)
+			case !tt.err && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case !tt.err && src.name != tt.want:
+				t.Errorf("got source %v, want %v", src.name, tt.want)
+			}
+		})
+	}
+}
+
+//a crashing replication from a source that's gone is reposted against the one it failed over to
+func TestFailoverReplacesCrashingReplication(t *testing.T) {
+	couch := newFakeCouch(t)
+	useLocalCouch(t, couch)
+	useSettings(t, func(s *settings.Settings) {
+		s.Hostname = "ITB-1101-CP1"
+		s.HistoryLimit = 0
+	})
+
+	primary, secondary := newFakeSource(t), newFakeSource(t)
+	useSources(t, []string{"primary", "secondary"}, primary, secondary)
+
+	ctx := context.Background()
+	config := DatabaseConfig{Database: "devices", Continuous: true}
+	replID := ReplicationID(config.Database, DirectionPull)
+
+	checkSources(ctx, 1)
+	if err := ScheduleReplication(ctx, config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	primary.set(http.StatusServiceUnavailable)
+	checkSources(ctx, 1)
+	if activeName() != "secondary" {
+		t.Fatalf("got active source %v, want secondary", activeName())
+	}
+
+	couch.setState(replID, "crashing")
+	if err := ScheduleReplication(ctx, config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	posted := couch.postedDocs()
+	if len(posted) != 2 {
+		t.Fatalf("got %v replication documents posted, want 2", len(posted))
+	}
+
+	if !strings.HasPrefix(posted[1].Source.URL, secondary.URL) {
+		t.Errorf("got source %v, want it reposted from %v", posted[1].Source.URL, secondary.URL)
+	}
+}
+
+func TestRestorePosted(t *testing.T) {
+	couch := newFakeCouch(t)
+	useLocalCouch(t, couch)
+
+	primary, secondary := newFakeSource(t), newFakeSource(t)
+	useSources(t, []string{"primary", "secondary"}, primary, secondary)
+	t.Cleanup(forgetPosted)
+
+	docs := map[string][2]string{
+		"auto_devices":    {secondary.URL + "/devices", couch.URL + "/devices"},
+		"auto_rooms_push": {couch.URL + "/rooms", primary.URL + "/rooms"},
+		"auto_buildings":  {strings.Replace(primary.URL, "://", "://admin:hunter2@", 1) + "/buildings", couch.URL + "/buildings"},
+		"auto_elsewhere":  {"http://elsewhere:5984/elsewhere", couch.URL + "/elsewhere"},
+	}
+
+	couch.createDB(couchclient.ReplicatorDB)
+	couch.mu.Lock()
+	for id, urls := range docs {
+		couch.dbs[couchclient.ReplicatorDB][id] = map[string]interface{}{
+			"_id":    id,
+			"_rev":   "1-fake",
+			"source": map[string]string{"url": urls[0]},
+			"target": map[string]string{"url": urls[1]},
+		}
+	}
+	couch.mu.Unlock()
+
+	if err := restorePosted(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"auto_devices":    "secondary",
+		"auto_rooms_push": "primary",
+		"auto_buildings":  "",
+		"auto_elsewhere":  "",
+	}
+
+	for id, name := range want {
+		if got := postedSource(id); got != name {
+			t.Errorf("got source %q for %v, want %q", got, id, name)
+		}
+	}
+}
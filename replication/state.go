@@ -0,0 +1,53 @@
+package replication
+
+import (
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+)
+
+//States the replication service can be in
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateDegraded = "degraded"
+	StateStopped  = "stopped"
+)
+
+//ServiceState is the overall state of the replication service
+type ServiceState struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+var serviceState = struct {
+	sync.RWMutex
+	s ServiceState
+}{s: ServiceState{State: StateStarting, Since: time.Now()}}
+
+func setState(state, reason string) {
+	serviceState.Lock()
+	defer serviceState.Unlock()
+
+	if serviceState.s.State == state && serviceState.s.Reason == reason {
+		return
+	}
+
+	if serviceState.s.State != state {
+		l.L.Infof("Replication service is now %v", state)
+		serviceState.s.Since = time.Now()
+	}
+
+	serviceState.s.State = state
+	serviceState.s.Reason = reason
+}
+
+//GetServiceState returns the current state of the replication service
+func GetServiceState() ServiceState {
+	serviceState.RLock()
+	defer serviceState.RUnlock()
+
+	return serviceState.s
+}
@@ -0,0 +1,216 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//ReplicationStatus is the current status of a replication job managed by the scheduler
+type ReplicationStatus struct {
+	Database     string           `json:"database"`
+	Config       DatabaseConfig   `json:"config"`
+	LastSuccess  *time.Time       `json:"last_success,omitempty"`
+	NextRun      *time.Time       `json:"next_run,omitempty"`
+	Replications []ReplicationLeg `json:"replications"`
+}
+
+//ReplicationLeg is the state of a single replication document belonging to a job
+type ReplicationLeg struct {
+	ID        string                    `json:"id"`
+	Direction string                    `json:"direction"`
+	State     string                    `json:"state"`
+	Error     string                    `json:"error,omitempty"`
+	Source    string                    `json:"source,omitempty"`
+	Scheduler *couchclient.SchedulerDoc `json:"scheduler,omitempty"`
+}
+
+type job struct {
+	sync.Mutex
+	config  DatabaseConfig
+	nextRun time.Time
+}
+
+var jobs = struct {
+	sync.RWMutex
+	m map[string]*job
+}{m: make(map[string]*job)}
+
+//trackJob registers a job with the status tracker, replacing any previous job for the same database
+func trackJob(config DatabaseConfig) *job {
+	j := &job{config: config}
+
+	jobs.Lock()
+	jobs.m[config.Database] = j
+	jobs.Unlock()
+
+	return j
+}
+
+//untrackJob removes a job from the status tracker, as long as it hasn't already been replaced
+func untrackJob(j *job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if cur, ok := jobs.m[j.config.Database]; ok && cur == j {
+		delete(jobs.m, j.config.Database)
+	}
+}
+
+func (j *job) setConfig(config DatabaseConfig) {
+	j.Lock()
+	j.config = config
+	j.Unlock()
+}
+
+//setNextRun records when the next run is going to happen. A zero next means there is no scheduled run (e.g. a
+//continuous replication)
+func (j *job) setNextRun(next time.Time) {
+	j.Lock()
+	j.nextRun = next
+	j.Unlock()
+}
+
+//lastSuccess is the last time every replication of config was known to be caught up with its source, which is when
+//the one furthest behind last caught up
+func lastSuccess(config DatabaseConfig) time.Time {
+	var toReturn time.Time
+	for i, id := range config.ReplicationIDs() {
+		t := lastCaughtUp(id)
+		if t.IsZero() {
+			return time.Time{}
+		}
+
+		if i == 0 || t.Before(toReturn) {
+			toReturn = t
+		}
+	}
+
+	return toReturn
+}
+
+func (j *job) status() ReplicationStatus {
+	j.Lock()
+	defer j.Unlock()
+
+	status := ReplicationStatus{
+		Database: j.config.Database,
+		Config:   j.config,
+	}
+
+	if t := lastSuccess(j.config); !t.IsZero() {
+		status.LastSuccess = &t
+	}
+
+	if !j.nextRun.IsZero() {
+		t := j.nextRun
+		status.NextRun = &t
+	}
+
+	return status
+}
+
+//trackedConfigs are the configs of every job currently being run
+func trackedConfigs() []DatabaseConfig {
+	jobs.RLock()
+	defer jobs.RUnlock()
+
+	configs := make([]DatabaseConfig, 0, len(jobs.m))
+	for _, j := range jobs.m {
+		configs = append(configs, j.status().Config)
+	}
+
+	sort.Slice(configs, func(i, k int) bool {
+		return configs[i].Database < configs[k].Database
+	})
+
+	return configs
+}
+
+//GetStatus returns the status of every replication job currently being run
+func GetStatus(ctx context.Context) []ReplicationStatus {
+	jobs.RLock()
+	list := make([]*job, 0, len(jobs.m))
+	for _, j := range jobs.m {
+		list = append(list, j)
+	}
+	jobs.RUnlock()
+
+	docs, err := getSchedulerDocs(ctx)
+
+	toReturn := make([]ReplicationStatus, 0, len(list))
+	for _, j := range list {
+		toReturn = append(toReturn, buildStatus(j, docs, err))
+	}
+
+	sort.Slice(toReturn, func(i, k int) bool {
+		return toReturn[i].Database < toReturn[k].Database
+	})
+
+	return toReturn
+}
+
+//GetDatabaseStatus returns the status of the replication job for a single database
+func GetDatabaseStatus(ctx context.Context, db string) (ReplicationStatus, *nerr.E) {
+	jobs.RLock()
+	j, ok := jobs.m[db]
+	jobs.RUnlock()
+
+	if !ok {
+		return ReplicationStatus{}, nerr.Create(fmt.Sprintf("No replication job is running for %v", db), "not_found")
+	}
+
+	docs, err := getSchedulerDocs(ctx)
+	return buildStatus(j, docs, err), nil
+}
+
+//getSchedulerDocs gets the scheduler's view of every replication document at once
+func getSchedulerDocs(ctx context.Context) (map[string]couchclient.SchedulerDoc, *nerr.E) {
+	docs, err := localCouch.SchedulerDocs(ctx)
+	if err != nil {
+		return nil, translateCouchErr(err).Add("Issue checking replication statuses")
+	}
+
+	return docs, nil
+}
+
+//buildStatus fills in the state of each replication document of a job from the scheduler's docs, or the error
+//getting them. Documents the scheduler doesn't have are not_started.
+func buildStatus(j *job, docs map[string]couchclient.SchedulerDoc, docsErr *nerr.E) ReplicationStatus {
+	status := j.status()
+
+	directions, err := status.Config.Directions()
+	if err != nil {
+		status.Replications = []ReplicationLeg{}
+		return status
+	}
+
+	for _, direction := range directions {
+		leg := ReplicationLeg{
+			ID:        ReplicationID(status.Database, direction),
+			Direction: direction,
+		}
+		leg.Source = postedSource(leg.ID)
+
+		state, ok := docs[leg.ID]
+		switch {
+		case docsErr != nil:
+			leg.State = "unknown"
+			leg.Error = docsErr.Error()
+		case !ok:
+			leg.State = "not_started"
+		default:
+			leg.State = state.State
+			leg.Scheduler = &state
+		}
+
+		status.Replications = append(status.Replications, leg)
+	}
+
+	return status
+}
@@ -0,0 +1,54 @@
+package replication
+
+import (
+	"context"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+func remoteTLSOptions() couchclient.TLSOptions {
+	return couchclient.TLSOptions{
+		CAFile:   cfg.RemoteCAFile,
+		CertFile: cfg.RemoteCertFile,
+		KeyFile:  cfg.RemoteKeyFile,
+		Pins:     cfg.RemotePins,
+	}
+}
+
+//configureReplicatorTLS points the local couch server's replicator at the certificate files it should use to connect
+//to the remote server. Couch only supports these for the whole replicator, not per replication document, and has no
+//support for pinning.
+func configureReplicatorTLS(ctx context.Context) *nerr.E {
+	values := map[string]string{
+		"ssl_trusted_certificates_file": cfg.ReplicatorCAFile,
+		"cert_file":                     cfg.ReplicatorCertFile,
+		"key_file":                      cfg.ReplicatorKeyFile,
+	}
+
+	set := false
+	for key, val := range values {
+		if len(val) == 0 {
+			continue
+		}
+
+		if err := localCouch.SetNodeConfig(ctx, "replicator", key, val); err != nil {
+			return translateCouchErr(err).Addf("Couldn't set the replicator's %v", key)
+		}
+		set = true
+	}
+
+	if !set {
+		return nil
+	}
+
+	if len(cfg.ReplicatorCAFile) > 0 {
+		if err := localCouch.SetNodeConfig(ctx, "replicator", "verify_ssl_certificates", "true"); err != nil {
+			return translateCouchErr(err).Add("Couldn't turn on certificate verification for the replicator")
+		}
+	}
+
+	l.L.Info("Configured TLS for the local couch server's replicator")
+	return nil
+}
@@ -0,0 +1,168 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//ValidationReport is the result of validating a replication config document
+type ValidationReport struct {
+	Valid    bool              `json:"valid"`
+	Errors   []ValidationIssue `json:"errors"`
+	Warnings []ValidationIssue `json:"warnings"`
+}
+
+//ValidationIssue is a single problem found in a config document. Path is the location of the problem in the
+//document, e.g. rules[0].replications[1].interval
+type ValidationIssue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (r *ValidationReport) errorf(path, format string, a ...interface{}) {
+	r.Errors = append(r.Errors, ValidationIssue{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+func (r *ValidationReport) warnf(path, format string, a ...interface{}) {
+	r.Warnings = append(r.Warnings, ValidationIssue{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+//ValidateConfig checks a replication config document for problems. Databases are checked against the remote couch
+//server they'd be replicated from, and if it can't be reached or none is configured that's reported as a warning.
+func ValidateConfig(ctx context.Context, config ReplicationConfig) ValidationReport {
+	report := ValidationReport{
+		Errors:   []ValidationIssue{},
+		Warnings: []ValidationIssue{},
+	}
+
+	if len(config.ID) == 0 {
+		report.errorf("_id", "document has no _id")
+	}
+
+	if len(config.Rules) == 0 {
+		report.warnf("rules", "document has no rules")
+	}
+
+	//only check each database on the server once
+	checked := make(map[string]bool)
+
+	for i, rule := range config.Rules {
+		rulePath := fmt.Sprintf("rules[%d]", i)
+
+		if _, err := regexp.Compile(rule.Hostname); err != nil {
+			report.errorf(rulePath+".hostname", "invalid hostname regex %q: %v", rule.Hostname, err)
+		}
+
+		seen := make(map[string]int)
+		targets := make(map[string]string)
+		for k, c := range rule.Replications {
+			path := fmt.Sprintf("%s.replications[%d]", rulePath, k)
+
+			if len(c.Database) == 0 {
+				report.errorf(path+".database", "database is required")
+				continue
+			}
+
+			if prev, ok := seen[c.Database]; ok {
+				report.errorf(path+".database", "%v is already configured at %s.replications[%d]", c.Database, rulePath, prev)
+			}
+			seen[c.Database] = k
+
+			if other, ok := targets[c.TargetDB()]; ok && other != c.Database && c.Merge != MergeRemove {
+				report.warnf(path+".target_database", "%v and %v both replicate into %v", other, c.Database, c.TargetDB())
+			}
+			targets[c.TargetDB()] = c.Database
+
+			validateDatabaseConfig(&report, path, c)
+
+			if c.Merge == MergeRemove {
+				continue
+			}
+
+			//a config can still be checked offline, just not against the databases on the remote server
+			if len(sourceClients()) == 0 {
+				report.warnf(path+".database", "can't check that %v exists without a remote couch server", c.SourceDB())
+				continue
+			}
+
+			src, err := replicationSource(c)
+			if err != nil {
+				report.errorf(path+".source_server", "%v", err.Error())
+				continue
+			}
+
+			key := src.name + "/" + c.SourceDB()
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+
+			if _, err := src.client.GetDatabase(ctx, c.SourceDB()); err != nil {
+				if couchclient.IsNotFound(err) {
+					report.errorf(path+".database", "unknown database %v on %v", c.SourceDB(), src.name)
+				} else {
+					report.warnf(path+".database", "couldn't check that %v exists on %v: %v", c.SourceDB(), src.name, err)
+				}
+			}
+		}
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+func validateDatabaseConfig(report *ValidationReport, path string, c DatabaseConfig) {
+	switch c.Merge {
+	case "", MergeOverride, MergeAdd:
+	case MergeRemove:
+		//nothing else about a removed database matters
+		return
+	default:
+		report.errorf(path+".merge", "invalid merge strategy %q", c.Merge)
+	}
+
+	if _, err := c.Directions(); err != nil {
+		report.errorf(path+".direction", "invalid direction %q", c.Direction)
+	}
+
+	switch {
+	case c.Continuous || len(c.Schedule) > 0:
+	case c.Interval == 0:
+		report.warnf(path+".interval", "no interval is set, the %v second minimum will be used", cfg.MinInterval)
+	case c.Interval < cfg.MinInterval:
+		report.errorf(path+".interval", "interval of %v is under the %v second minimum", c.Interval, cfg.MinInterval)
+	}
+
+	if c.Selector != nil && len(c.Filter) > 0 {
+		report.errorf(path, "only one of selector and filter may be used")
+	}
+
+	if len(c.QueryParams) > 0 && len(c.Filter) == 0 {
+		report.warnf(path+".query_params", "query_params are only used with a filter")
+	}
+
+	if c.Continuous && (len(c.Schedule) > 0 || len(c.QuietWindows) > 0) {
+		report.warnf(path, "schedule and quiet_windows are ignored for continuous replications")
+	}
+
+	if _, err := newSchedule(c); err != nil {
+		report.errorf(path, "%v", err.Error())
+	}
+
+	if c.Retry != nil {
+		if c.Retry.Multiplier != 0 && c.Retry.Multiplier < 1 {
+			report.errorf(path+".retry.multiplier", "multiplier must be at least 1")
+		}
+
+		if c.Retry.Jitter != nil && (*c.Retry.Jitter < 0 || *c.Retry.Jitter > 1) {
+			report.errorf(path+".retry.jitter", "jitter must be between 0 and 1")
+		}
+
+		if c.Retry.MaxDelay > 0 && c.Retry.MaxDelay < c.Retry.InitialDelay {
+			report.errorf(path+".retry.max_delay", "max_delay is less than initial_delay")
+		}
+	}
+}
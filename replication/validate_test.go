@@ -0,0 +1,58 @@
+package replication
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidateConfigIntervals(t *testing.T) {
+	//every database exists on the remote server
+	useSources(t, []string{"primary"}, newFakeSource(t))
+
+	config := ReplicationConfig{
+		ID: "default",
+		Rules: []HostConfig{{
+			Hostname: ".*",
+			Replications: []DatabaseConfig{
+				{Database: "none"},
+				{Database: "low", Interval: 5},
+				{Database: "enough", Interval: 60},
+				{Database: "continuous", Continuous: true},
+				{Database: "scheduled", Schedule: "0 * * * *"},
+			},
+		}},
+	}
+
+	report := ValidateConfig(context.Background(), config)
+	if report.Valid {
+		t.Errorf("got a valid report, want an interval under the minimum to make it invalid")
+	}
+
+	if len(report.Errors) != 1 || report.Errors[0].Path != "rules[0].replications[1].interval" {
+		t.Errorf("got errors %+v, want only one for rules[0].replications[1].interval", report.Errors)
+	}
+
+	if len(report.Warnings) != 1 || report.Warnings[0].Path != "rules[0].replications[0].interval" {
+		t.Errorf("got warnings %+v, want only one for rules[0].replications[0].interval", report.Warnings)
+	}
+}
+
+func TestValidateConfigOffline(t *testing.T) {
+	config := ReplicationConfig{
+		ID: "default",
+		Rules: []HostConfig{{
+			Hostname:     ".*",
+			Replications: []DatabaseConfig{{Database: "devices", Interval: 60}},
+		}},
+	}
+
+	//there's no remote server to check the databases against
+	report := ValidateConfig(context.Background(), config)
+	if !report.Valid || len(report.Errors) > 0 {
+		t.Errorf("got errors %+v, want a config that can't be checked against a remote server to be valid", report.Errors)
+	}
+
+	if len(report.Warnings) != 1 || report.Warnings[0].Path != "rules[0].replications[0].database" {
+		t.Errorf("got warnings %+v, want only one for rules[0].replications[0].database", report.Warnings)
+	}
+}
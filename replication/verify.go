@@ -0,0 +1,346 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/byuoitav/common/log"
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+)
+
+//Results of verifying a database
+const (
+	VerifyInSync   = "in_sync"
+	VerifyBehind   = "behind"
+	VerifyDiverged = "diverged"
+	VerifyUnknown  = "unknown"
+)
+
+//Verification is the result of checking a database against the database it's replicated from
+type Verification struct {
+	Database  string          `json:"database"`
+	State     string          `json:"state"`
+	Reasons   []string        `json:"reasons,omitempty"`
+	Server    string          `json:"server,omitempty"`
+	From      DatabaseSummary `json:"from"`
+	To        DatabaseSummary `json:"to"`
+	Sample    *SampleResult   `json:"sample,omitempty"`
+	CheckedAt time.Time       `json:"checked_at"`
+	Error     string          `json:"error,omitempty"`
+}
+
+//DatabaseSummary is what's compared about each side of a replication
+type DatabaseSummary struct {
+	Location  string      `json:"location"`
+	Name      string      `json:"name"`
+	DocCount  int         `json:"doc_count"`
+	UpdateSeq interface{} `json:"update_seq"`
+}
+
+//SampleResult is the outcome of comparing the revisions of a sample of documents. Missing and Behind documents
+//haven't been replicated yet, and Diverged ones have been changed on the side being replicated to.
+type SampleResult struct {
+	Checked  int      `json:"checked"`
+	Missing  []string `json:"missing,omitempty"`
+	Behind   []string `json:"behind,omitempty"`
+	Diverged []string `json:"diverged,omitempty"`
+}
+
+//the last result of verifying each database
+var verifications = struct {
+	sync.RWMutex
+	m map[string]Verification
+}{m: make(map[string]Verification)}
+
+//VerifyAll checks every database currently being replicated against its source
+func VerifyAll(ctx context.Context) []Verification {
+	configs := trackedConfigs()
+
+	//without the tasks the progress just isn't checked
+	tasks, _ := getReplicationTasks(ctx)
+
+	toReturn := make([]Verification, 0, len(configs))
+	for _, config := range configs {
+		toReturn = append(toReturn, verify(ctx, config, tasks))
+	}
+
+	return toReturn
+}
+
+//VerifyDatabase checks a single database being replicated against its source
+func VerifyDatabase(ctx context.Context, db string) (Verification, *nerr.E) {
+	jobs.RLock()
+	j, ok := jobs.m[db]
+	jobs.RUnlock()
+
+	if !ok {
+		return Verification{}, nerr.Create(fmt.Sprintf("No replication job is running for %v", db), "not_found")
+	}
+
+	tasks, _ := getReplicationTasks(ctx)
+	return verify(ctx, j.status().Config, tasks), nil
+}
+
+//GetVerifications returns the last result of verifying each database currently being replicated
+func GetVerifications() []Verification {
+	jobs.RLock()
+	defer jobs.RUnlock()
+
+	verifications.RLock()
+	defer verifications.RUnlock()
+
+	toReturn := make([]Verification, 0, len(verifications.m))
+	for db, v := range verifications.m {
+		if _, ok := jobs.m[db]; ok {
+			toReturn = append(toReturn, v)
+		}
+	}
+
+	sort.Slice(toReturn, func(i, k int) bool {
+		return toReturn[i].Database < toReturn[k].Database
+	})
+
+	return toReturn
+}
+
+//verify compares the doc counts of a database and the database it's replicated from, whether replication has caught
+//up with the source's update_seq, and the revisions of a sample of the documents that should have been replicated
+func verify(ctx context.Context, config DatabaseConfig, tasks replicationTasks) Verification {
+	v := Verification{
+		Database:  config.Database,
+		State:     VerifyUnknown,
+		CheckedAt: time.Now(),
+	}
+
+	defer func() {
+		verifications.Lock()
+		verifications.m[v.Database] = v
+		verifications.Unlock()
+	}()
+
+	directions, err := config.Directions()
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+
+	src, err := replicationSource(config)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+	v.Server = src.name
+
+	remote := DatabaseSummary{Location: src.name, Name: config.SourceDB()}
+	local := DatabaseSummary{Location: "local", Name: config.TargetDB()}
+
+	//pushes are checked the other way around
+	fromClient, toClient := src.client, localCouch
+	v.From, v.To = remote, local
+	if directions[0] == DirectionPush {
+		fromClient, toClient = localCouch, src.client
+		v.From, v.To = local, remote
+	}
+	both := len(directions) > 1
+
+	if err := summarize(ctx, fromClient, &v.From); err != nil {
+		v.Error = err.Error()
+		return v
+	}
+
+	if err := summarize(ctx, toClient, &v.To); err != nil {
+		v.Error = err.Error()
+		return v
+	}
+
+	var behind, diverged []string
+
+	//if only some of the documents are replicated the counts won't match
+	selector, err := verifySelector(config)
+	switch {
+	case err != nil:
+		v.Error = err.Error()
+		return v
+	case selector != nil || len(config.Filter) > 0:
+	case v.To.DocCount < v.From.DocCount:
+		behind = append(behind, fmt.Sprintf("%v has %v fewer documents than %v", v.To.Location, v.From.DocCount-v.To.DocCount, v.From.Location))
+	case v.To.DocCount > v.From.DocCount && both:
+		behind = append(behind, fmt.Sprintf("%v has %v fewer documents than %v", v.From.Location, v.To.DocCount-v.From.DocCount, v.To.Location))
+	case v.To.DocCount > v.From.DocCount:
+		diverged = append(diverged, fmt.Sprintf("%v has %v more documents than %v", v.To.Location, v.To.DocCount-v.From.DocCount, v.From.Location))
+	}
+
+	if tasks.tasks != nil {
+		for _, direction := range directions {
+			p := legProgress(ctx, config, direction, tasks)
+			if p.ChangesBehind != nil && *p.ChangesBehind > 0 {
+				behind = append(behind, fmt.Sprintf("%v is about %v changes behind its source's update_seq", p.ID, *p.ChangesBehind))
+			}
+		}
+	}
+
+	//a filter can't be run outside of couch, so there's no way to tell which documents should have been replicated
+	if cfg.VerifySampleSize > 0 && len(config.Filter) == 0 {
+		sample, err := sampleRevs(ctx, fromClient, toClient, v.From, v.To, selector, both)
+		if err != nil {
+			v.Error = err.Error()
+			return v
+		}
+		v.Sample = &sample
+
+		if n := len(sample.Missing) + len(sample.Behind); n > 0 {
+			behind = append(behind, fmt.Sprintf("%v of %v sampled documents haven't been replicated", n, sample.Checked))
+		}
+
+		if n := len(sample.Diverged); n > 0 {
+			diverged = append(diverged, fmt.Sprintf("%v of %v sampled documents have been changed in %v", n, sample.Checked, v.To.Location))
+		}
+	}
+
+	switch {
+	case len(diverged) > 0:
+		v.State = VerifyDiverged
+	case len(behind) > 0:
+		v.State = VerifyBehind
+	default:
+		v.State = VerifyInSync
+	}
+	v.Reasons = append(diverged, behind...)
+
+	return v
+}
+
+//summarize fills in the doc count and update_seq of a database
+func summarize(ctx context.Context, client *couchclient.Client, s *DatabaseSummary) *nerr.E {
+	info, err := client.GetDatabase(ctx, s.Name)
+	if err != nil {
+		return translateCouchErr(err).Addf("Couldn't get the %v database from %v", s.Name, s.Location)
+	}
+
+	s.DocCount = info.DocCount
+	s.UpdateSeq = info.UpdateSeq
+	return nil
+}
+
+//verifySelector is the selector a database is replicated with, after the template variables are filled in
+func verifySelector(config DatabaseConfig) (interface{}, *nerr.E) {
+	var rdoc couchclient.ReplicationDoc
+	if err := applyFilter(&rdoc, config, cfg.Hostname); err != nil {
+		return nil, err
+	}
+
+	return rdoc.Selector, nil
+}
+
+//sampleRevs picks a random run of documents matching selector from the database being replicated from, and compares
+//their revisions with the database being replicated to
+func sampleRevs(ctx context.Context, fromClient, toClient *couchclient.Client, from, to DatabaseSummary, selector interface{}, both bool) (SampleResult, *nerr.E) {
+	var sample SampleResult
+
+	req := couchclient.FindRequest{
+		Selector: selector,
+		Limit:    cfg.VerifySampleSize,
+	}
+
+	total := from.DocCount
+	switch {
+	case selector == nil:
+		req.Selector = map[string]interface{}{"_id": map[string]interface{}{"$gt": nil}}
+	case to.DocCount < total:
+		//we don't know how many documents match the selector, but it's about as many as have been replicated
+		total = to.DocCount
+	}
+
+	if n := total - cfg.VerifySampleSize; n > 0 {
+		jitterRand.Lock()
+		req.Skip = jitterRand.r.Intn(n + 1)
+		jitterRand.Unlock()
+	}
+
+	docs, err := fromClient.Find(ctx, from.Name, req)
+	if err != nil {
+		return sample, translateCouchErr(err).Addf("Couldn't sample documents from %v", from.Location)
+	}
+
+	if len(docs) == 0 {
+		return sample, nil
+	}
+
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+
+	revs, err := toClient.DocumentRevs(ctx, to.Name, ids)
+	if err != nil {
+		return sample, translateCouchErr(err).Addf("Couldn't get sampled documents from %v", to.Location)
+	}
+
+	sample.Checked = len(docs)
+	for _, doc := range docs {
+		rev, ok := revs[doc.ID]
+		switch {
+		case !ok:
+			sample.Missing = append(sample.Missing, doc.ID)
+		case rev.Rev == doc.Rev:
+		case revGeneration(rev.Rev) < revGeneration(doc.Rev) || both:
+			sample.Behind = append(sample.Behind, doc.ID)
+		default:
+			sample.Diverged = append(sample.Diverged, doc.ID)
+		}
+	}
+
+	return sample, nil
+}
+
+//revGeneration is the number of times a document has been changed, from the start of its revision
+func revGeneration(rev string) int {
+	if i := strings.Index(rev, "-"); i >= 0 {
+		rev = rev[:i]
+	}
+
+	n, _ := strconv.Atoi(rev)
+	return n
+}
+
+//logVerifications verifies every database and logs anything that isn't in sync
+func logVerifications(ctx context.Context) {
+	for _, v := range VerifyAll(ctx) {
+		switch v.State {
+		case VerifyInSync:
+			l.L.Debugf("%v is in sync with %v", v.Database, v.From.Location)
+		case VerifyBehind:
+			l.L.Infof("%v is behind %v: %v", v.Database, v.From.Location, strings.Join(v.Reasons, "; "))
+		case VerifyDiverged:
+			l.L.Warnf("%v has diverged from %v: %v", v.Database, v.From.Location, strings.Join(v.Reasons, "; "))
+		default:
+			l.L.Warnf("Couldn't verify %v: %v", v.Database, v.Error)
+		}
+	}
+}
+
+//watchVerifications verifies every database on the verify interval, until ctx is cancelled
+func watchVerifications(ctx context.Context) {
+	if cfg.VerifyInterval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(time.Duration(cfg.VerifyInterval) * time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		logVerifications(ctx)
+	}
+}
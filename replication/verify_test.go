@@ -0,0 +1,205 @@
+package replication
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"github.com/byuoitav/couch-db-repl/settings"
+)
+
+func TestVerify(t *testing.T) {
+	useSettings(t, func(s *settings.Settings) {
+		s.Hostname = "ITB-1101-CP1"
+		s.VerifySampleSize = 10
+	})
+
+	tests := []struct {
+		name      string
+		direction string
+		remote    map[string]string
+		local     map[string]string
+		state     string
+		sample    SampleResult
+	}{
+		{
+			name:   "in sync",
+			remote: map[string]string{"a": "1-x", "b": "2-y"},
+			local:  map[string]string{"a": "1-x", "b": "2-y"},
+			state:  VerifyInSync,
+			sample: SampleResult{Checked: 2},
+		},
+		{
+			name:   "missing a document",
+			remote: map[string]string{"a": "1-x", "b": "2-y"},
+			local:  map[string]string{"a": "1-x"},
+			state:  VerifyBehind,
+			sample: SampleResult{Checked: 2, Missing: []string{"b"}},
+		},
+		{
+			name:   "older revision",
+			remote: map[string]string{"a": "3-x"},
+			local:  map[string]string{"a": "2-x"},
+			state:  VerifyBehind,
+			sample: SampleResult{Checked: 1, Behind: []string{"a"}},
+		},
+		{
+			name:   "newer revision",
+			remote: map[string]string{"a": "2-x"},
+			local:  map[string]string{"a": "3-z"},
+			state:  VerifyDiverged,
+			sample: SampleResult{Checked: 1, Diverged: []string{"a"}},
+		},
+		{
+			name:   "same generation",
+			remote: map[string]string{"a": "2-x"},
+			local:  map[string]string{"a": "2-y"},
+			state:  VerifyDiverged,
+			sample: SampleResult{Checked: 1, Diverged: []string{"a"}},
+		},
+		{
+			name:      "same generation both ways",
+			direction: DirectionBoth,
+			remote:    map[string]string{"a": "2-x"},
+			local:     map[string]string{"a": "2-y"},
+			state:     VerifyBehind,
+			sample:    SampleResult{Checked: 1, Behind: []string{"a"}},
+		},
+		{
+			name:   "extra local document",
+			remote: map[string]string{"a": "1-x"},
+			local:  map[string]string{"a": "1-x", "b": "1-y"},
+			state:  VerifyDiverged,
+			sample: SampleResult{Checked: 1},
+		},
+		{
+			name:   "missing local database",
+			remote: map[string]string{"a": "1-x"},
+			state:  VerifyUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := newFakeCouch(t), newFakeCouch(t)
+			useLocalCouch(t, local)
+			useRemoteCouch(t, remote)
+
+			remote.createDB("rooms")
+			for id, rev := range tt.remote {
+				remote.put("rooms", id, rev)
+			}
+			if tt.local != nil {
+				local.createDB("rooms")
+			}
+			for id, rev := range tt.local {
+				local.put("rooms", id, rev)
+			}
+
+			v := verify(context.Background(), DatabaseConfig{Database: "rooms", Direction: tt.direction}, replicationTasks{})
+			if v.State != tt.state {
+				t.Fatalf("got state %v, want %v: %+v", v.State, tt.state, v)
+			}
+
+			if tt.state == VerifyUnknown {
+				if len(v.Error) == 0 {
+					t.Errorf("got no error for a database that couldn't be verified")
+				}
+				return
+			}
+
+			if v.Sample == nil || !reflect.DeepEqual(*v.Sample, tt.sample) {
+				t.Errorf("got sample %+v, want %+v", v.Sample, tt.sample)
+			}
+			if tt.state != VerifyInSync && len(v.Reasons) == 0 {
+				t.Errorf("got no reasons for a database that's %v", v.State)
+			}
+		})
+	}
+}
+
+func TestSampleRevs(t *testing.T) {
+	useSettings(t, func(s *settings.Settings) {
+		s.VerifySampleSize = 3
+	})
+
+	from, to := newFakeCouch(t), newFakeCouch(t)
+	to.createDB("rooms")
+
+	ids := map[string]bool{}
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		from.put("rooms", id, "1-x")
+		to.put("rooms", id, "1-x")
+		ids[id] = true
+	}
+
+	fromClient, toClient := couchclient.New(from.URL, "", ""), couchclient.New(to.URL, "", "")
+	fromSummary := DatabaseSummary{Location: "primary", Name: "rooms", DocCount: len(ids)}
+	toSummary := DatabaseSummary{Location: "local", Name: "rooms", DocCount: len(ids)}
+
+	//without a selector a random run of documents is sampled
+	for i := 0; i < 20; i++ {
+		sample, err := sampleRevs(context.Background(), fromClient, toClient, fromSummary, toSummary, nil, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if sample.Checked != 3 || len(sample.Missing)+len(sample.Behind)+len(sample.Diverged) > 0 {
+			t.Errorf("got sample %+v, want 3 documents checked and in sync", sample)
+		}
+	}
+
+	//with a selector the run is bounded by how many documents have been replicated
+	partial := newFakeCouch(t)
+	for _, id := range []string{"a", "b", "c"} {
+		partial.put("rooms", id, "1-x")
+	}
+
+	partialSummary := DatabaseSummary{Location: "local", Name: "rooms", DocCount: 5}
+	missing := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		sample, err := sampleRevs(context.Background(), fromClient, couchclient.New(partial.URL, "", ""), fromSummary, partialSummary, map[string]interface{}{"type": "room"}, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, id := range sample.Missing {
+			missing[id] = true
+		}
+	}
+
+	if !missing["d"] && !missing["e"] {
+		t.Errorf("got only the first documents sampled with a selector, want a random run of them")
+	}
+	if missing["f"] || missing["g"] {
+		t.Errorf("got %v missing, want the sample to stay within the replicated doc count", missing)
+	}
+
+	empty := newFakeCouch(t)
+	empty.createDB("rooms")
+
+	sample, err := sampleRevs(context.Background(), couchclient.New(empty.URL, "", ""), toClient, fromSummary, toSummary, nil, false)
+	if err != nil || sample.Checked != 0 {
+		t.Errorf("got sample %+v and error %v from an empty database, want nothing checked", sample, err)
+	}
+}
+
+func TestRevGeneration(t *testing.T) {
+	tests := []struct {
+		rev  string
+		want int
+	}{
+		{"1-967a00dff5e02add41819138abb3284d", 1},
+		{"12-abc", 12},
+		{"3", 3},
+		{"", 0},
+		{"not-a-rev", 0},
+	}
+
+	for _, tt := range tests {
+		if got := revGeneration(tt.rev); got != tt.want {
+			t.Errorf("got generation %v for %q, want %v", got, tt.rev, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package replication
+
+import (
+	"context"
+	"time"
+
+	"github.com/byuoitav/common/log"
+)
+
+//Ways of noticing changes to the config database
+const (
+	//ConfigWatchPoll checks the config every time the config database is replicated
+	ConfigWatchPoll = "poll"
+
+	//ConfigWatchChanges replicates the config database continuously, and follows its _changes feed to pick up
+	//changes as soon as they arrive. Polling is kept as a fallback.
+	ConfigWatchChanges = "changes"
+)
+
+//how long each long poll of the _changes feed waits for a change
+const changesPollTimeout = 25 * time.Second
+
+//watchingChanges reports whether the changes feed is being followed. Only the config database has one.
+func watchingChanges() bool {
+	return cfg.ConfigWatchMode == ConfigWatchChanges && replicatingConfig()
+}
+
+//watchConfig follows the _changes feed of the local config database, sending on changed whenever a document that
+//affects this host changes. It returns once ctx is cancelled.
+func watchConfig(ctx context.Context, hostname string, changed chan<- struct{}) {
+	relevant := make(map[string]bool)
+	for _, id := range configDocIDs(hostname) {
+		relevant[id] = true
+	}
+
+	log.L.Infof("Watching %v for changes to %v", REPL_CONFIG_DB, configDocIDs(hostname))
+
+	since := "now"
+	b := newStartupBackoff()
+
+	for {
+		resp, err := localCouch.LongPollChanges(ctx, REPL_CONFIG_DB, since, changesPollTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			wait := b.next()
+			log.L.Warnf("Unable to follow the %v changes feed, trying again in %v: %v", REPL_CONFIG_DB, wait, err)
+			if !sleep(ctx, wait) {
+				return
+			}
+			continue
+		}
+
+		b.reset()
+		since = resp.Since()
+
+		for _, change := range resp.Results {
+			if !relevant[change.ID] {
+				continue
+			}
+
+			log.L.Infof("Config document %v changed", change.ID)
+
+			//a pending notification covers this one too
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+			break
+		}
+	}
+}
+
+//waitForConfig waits for d, or until the config or the active source changes. It returns false if ctx is cancelled
+//first.
+func waitForConfig(ctx context.Context, d time.Duration, changed <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-changed:
+		return true
+	case <-sourceChanged():
+		return true
+	case <-t.C:
+		return true
+	}
+}
@@ -1,24 +1,53 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // replication schedules can be in any timezone, and the container doesn't have zoneinfo
 
 	"github.com/byuoitav/authmiddleware"
 	"github.com/byuoitav/common"
 	"github.com/byuoitav/common/log"
 	"github.com/byuoitav/couch-db-repl/handlers"
+	"github.com/byuoitav/couch-db-repl/internal/redact"
 	"github.com/byuoitav/couch-db-repl/replication"
+	"github.com/byuoitav/couch-db-repl/settings"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	port := ":7012"
+	// keep credentials out of the logs
+	log.L = redact.Logger(log.L)
+
+	s, err := settings.Load(os.Args[1:])
+	if err != nil {
+		log.L.Fatal(err.Error())
+	}
+
+	if err := log.SetLevel(s.LogLevel); err != nil {
+		log.L.Fatalf("Invalid log level %q: %v", s.LogLevel, err)
+	}
+
+	if err := replication.Configure(s); err != nil {
+		log.L.Fatal(err.Error())
+	}
+
 	router := common.NewRouter()
 
 	router.Pre(middleware.RemoveTrailingSlash())
 	router.Use(middleware.CORS())
 
+	router.GET("/status", handlers.Status)
+	router.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	router.GET("/healthz", handlers.Healthz)
+	router.GET("/readyz", handlers.Readyz)
+
 	// Use the `secure` routing group to require authentication
 	secure := router.Group("", echo.WrapMiddleware(authmiddleware.Authenticate))
 
@@ -26,20 +55,58 @@ func main() {
 	secure.GET("/log-level", log.GetLogLevel)
 
 	secure.GET("/replication/start", handlers.ReplicateNow)
+	secure.POST("/replication/:db/start", handlers.ReplicateDatabaseNow)
+	secure.GET("/replication/status", handlers.ReplicationStatus)
+	secure.GET("/replication/status/:db", handlers.DatabaseReplicationStatus)
+	secure.GET("/replication/progress", handlers.ReplicationProgress)
+	secure.GET("/replication/progress/:db", handlers.DatabaseReplicationProgress)
+	secure.GET("/replication/verify", handlers.VerifyReplications)
+	secure.GET("/replication/verify/:db", handlers.VerifyDatabaseReplication)
+	secure.GET("/replication/history/:db", handlers.ReplicationHistory)
+	secure.GET("/replication/sources", handlers.ReplicationSources)
+	secure.GET("/replication/events", handlers.ReplicationEvents)
+
+	secure.POST("/config/validate", handlers.ValidateConfig)
+	secure.GET("/config/resolve", handlers.ResolveConfig)
 
 	server := &http.Server{
-		Addr:           port,
+		Addr:           s.Port,
 		MaxHeaderBytes: 1024 * 10,
 	}
 
 	go func() {
-		if err := router.StartServer(server); err != nil {
+		if err := router.StartServer(server); err != nil && err != http.ErrServerClosed {
 			log.L.Fatal(err)
 		}
 	}()
 
-	replication.Init()
-	if err := replication.Start(); err != nil {
+	// stop everything when we're asked to
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		log.L.Infof("Received %v, shutting down", sig)
+		cancel()
+	}()
+
+	if err := replication.Init(ctx); err != nil {
+		log.L.Warnf("Replication didn't finish initializing: %v", err.Error())
+	} else if err := replication.Start(ctx); err != nil && ctx.Err() == nil {
 		log.L.Fatal(err)
 	}
+
+	// keep serving until we're told to stop, then drain the server
+	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.L.Errorf("Couldn't shut down the server cleanly: %v", err)
+	}
+
+	log.L.Info("Shut down")
 }
@@ -0,0 +1,512 @@
+package settings
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/common/nerr"
+	"github.com/byuoitav/couch-db-repl/internal/couchclient"
+	"sigs.k8s.io/yaml"
+)
+
+//Defaults for settings that aren't required
+const (
+	DefaultPort            = ":7012"
+	DefaultLogLevel        = "info"
+	DefaultInterval        = 300
+	DefaultMinInterval     = 10
+	DefaultWaitLimit       = 60
+	DefaultConfigSource    = "couch"
+	DefaultConfigWatchMode = "poll"
+	DefaultShutdownPolicy  = "leave"
+	DefaultReplicationAuth = "auto"
+	DefaultSourceCheck     = 30
+	DefaultProgress        = 60
+	DefaultVerifyInterval  = 3600
+	DefaultVerifySample    = 20
+	DefaultEventPoll       = 10
+	DefaultHistoryLimit    = 100
+	DefaultHostnamePattern = `^(?P<building>[^-]+)-(?P<room>[^-]+)(?:-(?P<device>.+))?$`
+)
+
+//Settings are everything that can be tuned about the service. They're loaded from, in increasing order of
+//precedence, the defaults, an optional JSON or YAML file, environment variables, and command line flags.
+type Settings struct {
+	//Port is the address the http server listens on
+	Port     string `json:"port"`
+	LogLevel string `json:"log_level"`
+
+	//Hostname is this host's SYSTEM_ID, and HostnamePattern is the regex used to break it up
+	Hostname        string `json:"hostname"`
+	HostnamePattern string `json:"hostname_pattern"`
+
+	//the local (target) couch server. The username and password can instead be read from files, e.g. docker
+	//secrets, which are checked for changes while running.
+	CouchAddr     string `json:"couch_addr"`
+	CouchUser     string `json:"couch_user"`
+	CouchPass     string `json:"couch_pass"`
+	CouchUserFile string `json:"couch_user_file"`
+	CouchPassFile string `json:"couch_pass_file"`
+
+	//the remote (source) couch server
+	RemoteAddr     string `json:"remote_addr"`
+	RemoteUser     string `json:"remote_user"`
+	RemotePass     string `json:"remote_pass"`
+	RemoteUserFile string `json:"remote_user_file"`
+	RemotePassFile string `json:"remote_pass_file"`
+
+	//RemoteSources are the remote servers to replicate from, in order of preference, as name=url or just url. If
+	//they aren't set, RemoteAddr is the only source. All of them use the remote username, password and TLS settings.
+	//SourceCheckInterval is how often (in seconds) they're checked to decide which to use.
+	RemoteSources       []string `json:"remote_sources"`
+	SourceCheckInterval int      `json:"source_check_interval"`
+
+	//RemoteAliases are other remote servers, as name=url, that databases can name in their config to replicate from
+	//instead of the sources. They also use the remote username, password and TLS settings.
+	RemoteAliases []string `json:"remote_aliases"`
+
+	//TLS for the remote server. RemotePins are base64 sha256 hashes of certificate public keys, one of which the
+	//server's chain must include.
+	RemoteCAFile   string   `json:"remote_ca_file"`
+	RemoteCertFile string   `json:"remote_cert_file"`
+	RemoteKeyFile  string   `json:"remote_key_file"`
+	RemotePins     []string `json:"remote_pins"`
+
+	//the files the local couch server's replicator should use to connect to the remote server, as the local couch
+	//server sees them. If any are set, they're written to the local server's replicator config at startup.
+	ReplicatorCAFile   string `json:"replicator_ca_file"`
+	ReplicatorCertFile string `json:"replicator_cert_file"`
+	ReplicatorKeyFile  string `json:"replicator_key_file"`
+
+	//ReplicationAuth is how credentials are given in replication documents: headers, which works with any version
+	//of couch, auth, which needs couch 3.2 or later, or auto to use auth when the local couch server supports it
+	ReplicationAuth string `json:"replication_auth"`
+
+	//where the replication config comes from
+	ConfigSource    string `json:"config_source"`
+	ConfigFile      string `json:"config_file"`
+	ConfigDocument  string `json:"config_document"`
+	ConfigWatchMode string `json:"config_watch_mode"`
+
+	//DefaultInterval is how often (in seconds) the config database is replicated if the config doesn't say,
+	//MinInterval is the floor for every interval, and WaitLimit is how many seconds to wait for the config
+	//database to replicate while starting
+	DefaultInterval int `json:"default_interval"`
+	MinInterval     int `json:"min_interval"`
+	WaitLimit       int `json:"wait_limit"`
+
+	//ProgressInterval is how often (in seconds) the progress of each replication is logged, or 0 to not log it
+	ProgressInterval int `json:"progress_interval"`
+
+	//VerifyInterval is how often (in seconds) the local copy of each database is checked against its source, or 0
+	//to only check it when asked, and VerifySampleSize is how many documents' revisions are compared each time
+	VerifyInterval   int `json:"verify_interval"`
+	VerifySampleSize int `json:"verify_sample_size"`
+
+	//EventURLs are posted each event, EventFile has each event appended to it as a line of JSON, and
+	//EventPollInterval is how often (in seconds) the state of each replication is checked for changes
+	EventURLs         []string `json:"event_urls"`
+	EventFile         string   `json:"event_file"`
+	EventPollInterval int      `json:"event_poll_interval"`
+
+	//EventOrigins are the origins besides this service's own that browsers can connect to the event stream from
+	EventOrigins []string `json:"event_origins"`
+
+	//HistoryLimit is how many runs of each database are kept in the replication history, or 0 to not keep any
+	HistoryLimit int `json:"history_limit"`
+
+	ShutdownPolicy  string `json:"shutdown_policy"`
+	StopReplication bool   `json:"stop_replication"`
+}
+
+//Default returns the settings used when nothing else is set
+func Default() Settings {
+	return Settings{
+		Port:                DefaultPort,
+		LogLevel:            DefaultLogLevel,
+		HostnamePattern:     DefaultHostnamePattern,
+		ReplicationAuth:     DefaultReplicationAuth,
+		SourceCheckInterval: DefaultSourceCheck,
+		ConfigSource:        DefaultConfigSource,
+		ConfigWatchMode:     DefaultConfigWatchMode,
+		DefaultInterval:     DefaultInterval,
+		MinInterval:         DefaultMinInterval,
+		WaitLimit:           DefaultWaitLimit,
+		ProgressInterval:    DefaultProgress,
+		VerifyInterval:      DefaultVerifyInterval,
+		VerifySampleSize:    DefaultVerifySample,
+		EventPollInterval:   DefaultEventPoll,
+		HistoryLimit:        DefaultHistoryLimit,
+		ShutdownPolicy:      DefaultShutdownPolicy,
+	}
+}
+
+//option is a setting that can be set by an environment variable or flag
+type option struct {
+	flag  string
+	env   string
+	usage string
+	value interface{} //*string, *[]string, *int, or *bool
+}
+
+func (s *Settings) options() []option {
+	return []option{
+		{"port", "PORT", "address for the http server to listen on", &s.Port},
+		{"log-level", "LOG_LEVEL", "log level", &s.LogLevel},
+		{"hostname", "SYSTEM_ID", "hostname of this device", &s.Hostname},
+		{"hostname-pattern", "HOSTNAME_PATTERN", "regex with building, room and device groups to parse the hostname", &s.HostnamePattern},
+		{"couch-addr", "COUCH_ADDR", "address of the local couch server", &s.CouchAddr},
+		{"couch-user", "COUCH_USER", "username for the local couch server", &s.CouchUser},
+		{"couch-pass", "COUCH_PASS", "password for the local couch server", &s.CouchPass},
+		{"remote-addr", "COUCH_REPL_ADDR", "address of the remote couch server", &s.RemoteAddr},
+		{"remote-user", "COUCH_REPL_USER", "username for the remote couch server", &s.RemoteUser},
+		{"remote-pass", "COUCH_REPL_PASS", "password for the remote couch server", &s.RemotePass},
+		{"couch-user-file", "COUCH_USER_FILE", "file with the username for the local couch server", &s.CouchUserFile},
+		{"couch-pass-file", "COUCH_PASS_FILE", "file with the password for the local couch server", &s.CouchPassFile},
+		{"remote-user-file", "COUCH_REPL_USER_FILE", "file with the username for the remote couch server", &s.RemoteUserFile},
+		{"remote-pass-file", "COUCH_REPL_PASS_FILE", "file with the password for the remote couch server", &s.RemotePassFile},
+		{"remote-sources", "COUCH_REPL_SOURCES", "comma separated remote couch servers to replicate from in order of preference, as name=url or url", &s.RemoteSources},
+		{"remote-aliases", "COUCH_REPL_ALIASES", "comma separated name=url remote couch servers that databases can choose to replicate from", &s.RemoteAliases},
+		{"source-check-interval", "SOURCE_CHECK_INTERVAL", "seconds between health checks of the remote couch servers", &s.SourceCheckInterval},
+		{"remote-ca-file", "COUCH_REPL_CA_FILE", "pem bundle of certificate authorities to trust for the remote couch server", &s.RemoteCAFile},
+		{"remote-cert-file", "COUCH_REPL_CERT_FILE", "client certificate for the remote couch server", &s.RemoteCertFile},
+		{"remote-key-file", "COUCH_REPL_KEY_FILE", "client key for the remote couch server", &s.RemoteKeyFile},
+		{"remote-pins", "COUCH_REPL_PINS", "comma separated base64 sha256 public key pins for the remote couch server", &s.RemotePins},
+		{"replicator-ca-file", "REPLICATOR_CA_FILE", "ca bundle for the local couch server's replicator to trust", &s.ReplicatorCAFile},
+		{"replicator-cert-file", "REPLICATOR_CERT_FILE", "client certificate for the local couch server's replicator", &s.ReplicatorCertFile},
+		{"replicator-key-file", "REPLICATOR_KEY_FILE", "client key for the local couch server's replicator", &s.ReplicatorKeyFile},
+		{"replication-auth", "REPLICATION_AUTH", "how credentials are given in replication documents: auto, headers, or auth", &s.ReplicationAuth},
+		{"config-source", "CONFIG_SOURCE", "where the replication config is read from: couch, file, or env", &s.ConfigSource},
+		{"config-file", "CONFIG_FILE", "replication config file, if the config source is file", &s.ConfigFile},
+		{"config-document", "CONFIG_DOCUMENT", "replication config document, if the config source is env", &s.ConfigDocument},
+		{"config-watch-mode", "CONFIG_WATCH_MODE", "how config changes are noticed: poll or changes", &s.ConfigWatchMode},
+		{"default-interval", "DEFAULT_INTERVAL", "seconds between replications of the config database if the config doesn't say", &s.DefaultInterval},
+		{"min-interval", "MIN_INTERVAL", "minimum seconds between replications", &s.MinInterval},
+		{"wait-limit", "WAIT_LIMIT", "seconds to wait for the config database to replicate while starting", &s.WaitLimit},
+		{"progress-interval", "PROGRESS_INTERVAL", "seconds between logging the progress of each replication, 0 to not log it", &s.ProgressInterval},
+		{"verify-interval", "VERIFY_INTERVAL", "seconds between checking local databases against their sources, 0 to only check on request", &s.VerifyInterval},
+		{"verify-sample-size", "VERIFY_SAMPLE_SIZE", "documents to compare the revisions of when checking a database, 0 to not compare any", &s.VerifySampleSize},
+		{"event-urls", "EVENT_URLS", "comma separated urls to post replication events to", &s.EventURLs},
+		{"event-file", "EVENT_FILE", "file to append replication events to as lines of JSON", &s.EventFile},
+		{"event-poll-interval", "EVENT_POLL_INTERVAL", "seconds between checking replications for state changes", &s.EventPollInterval},
+		{"event-origins", "EVENT_ORIGINS", "comma separated origins besides this service's own that can connect to the event stream", &s.EventOrigins},
+		{"history-limit", "HISTORY_LIMIT", "runs of each database to keep in the replication history, 0 to not keep any", &s.HistoryLimit},
+		{"shutdown-policy", "SHUTDOWN_POLICY", "what to do with replication documents on shutdown: leave or delete", &s.ShutdownPolicy},
+		{"stop-replication", "STOP_REPLICATION", "don't replicate anything", &s.StopReplication},
+	}
+}
+
+func (o option) set(val string) error {
+	switch v := o.value.(type) {
+	case *string:
+		*v = val
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				*v = append(*v, item)
+			}
+		}
+	case *int:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("%v must be a number", o.flag)
+		}
+		*v = i
+	case *bool:
+		//anything that isn't a bool counts as true, STOP_REPLICATION used to be set by just being non-empty
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			b = len(val) > 0
+		}
+		*v = b
+	}
+
+	return nil
+}
+
+//flagValue holds the value of a flag until the settings file and environment have been applied. Bool flags can be
+//given without a value.
+type flagValue struct {
+	val    string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return f.val
+}
+
+func (f *flagValue) Set(val string) error {
+	f.val = val
+	return nil
+}
+
+//IsBoolFlag is used by the flag package to allow a bare -flag
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+//Load builds the settings from the defaults, the settings file, the environment, and args. The settings file is
+//given by the -settings flag or SETTINGS_FILE. The settings are validated before they're returned.
+func Load(args []string) (Settings, *nerr.E) {
+	s := Default()
+
+	fs := flag.NewFlagSet("couch-db-repl", flag.ContinueOnError)
+	file := fs.String("settings", os.Getenv("SETTINGS_FILE"), "JSON or YAML settings file")
+
+	flags := make(map[string]*flagValue)
+	for _, o := range s.options() {
+		_, isBool := o.value.(*bool)
+		flags[o.flag] = &flagValue{isBool: isBool}
+		fs.Var(flags[o.flag], o.flag, fmt.Sprintf("%v (%v)", o.usage, o.env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return s, nerr.Translate(err).Add("Invalid flags").SetType("invalid_args")
+	}
+
+	if len(*file) > 0 {
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return s, nerr.Translate(err).Addf("Couldn't read settings file %v", *file)
+		}
+
+		if err := yaml.Unmarshal(data, &s); err != nil {
+			return s, nerr.Translate(err).Addf("Couldn't parse settings file %v", *file).SetType("invalid_args")
+		}
+	}
+
+	for _, o := range s.options() {
+		val := os.Getenv(o.env)
+		if len(val) == 0 {
+			continue
+		}
+
+		if err := o.set(val); err != nil {
+			return s, nerr.Translate(err).Addf("Invalid %v", o.env).SetType("invalid_args")
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, o := range s.options() {
+		if !set[o.flag] {
+			continue
+		}
+
+		if err := o.set(flags[o.flag].val); err != nil {
+			return s, nerr.Translate(err).Addf("Invalid -%v", o.flag).SetType("invalid_args")
+		}
+	}
+
+	//a bare port number is fine too
+	if len(s.Port) > 0 && !strings.Contains(s.Port, ":") {
+		s.Port = ":" + s.Port
+	}
+
+	return s, s.Validate()
+}
+
+//Validate checks that the required settings are there and the rest make sense
+func (s Settings) Validate() *nerr.E {
+	var problems []string
+	problemf := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if len(s.Port) == 0 {
+		problemf("a port is required")
+	}
+
+	if len(s.Hostname) == 0 {
+		problemf("a hostname (SYSTEM_ID) is required")
+	}
+
+	if re, err := regexp.Compile(s.HostnamePattern); err != nil {
+		problemf("invalid hostname pattern: %v", err)
+	} else if re.SubexpIndex("building") < 0 {
+		problemf("the hostname pattern has no building group")
+	}
+
+	if len(s.CouchAddr) == 0 {
+		problemf("the local couch address (COUCH_ADDR) is required")
+	}
+
+	switch s.ConfigSource {
+	case "couch":
+		if len(s.RemoteAddr)+len(s.RemoteSources) == 0 || len(s.RemoteUser)+len(s.RemoteUserFile) == 0 || len(s.RemotePass)+len(s.RemotePassFile) == 0 {
+			problemf("the remote couch address, username and password are required to read the config from couch")
+		}
+	case "file":
+		if len(s.ConfigFile) == 0 {
+			problemf("a config file (CONFIG_FILE) is required to read the config from a file")
+		}
+	case "env":
+		if len(s.ConfigDocument) == 0 {
+			problemf("a config document (CONFIG_DOCUMENT) is required to read the config from the environment")
+		}
+	default:
+		problemf("invalid config source %q", s.ConfigSource)
+	}
+
+	if s.ConfigWatchMode != "poll" && s.ConfigWatchMode != "changes" {
+		problemf("invalid config watch mode %q", s.ConfigWatchMode)
+	}
+
+	names := make(map[string]bool)
+	for _, source := range append(s.Sources(), s.Aliases()...) {
+		if len(source.Name) == 0 {
+			problemf("remote server alias %v needs a name", source.Addr)
+		}
+
+		if names[source.Name] {
+			problemf("there's more than one remote source named %v", source.Name)
+		}
+		names[source.Name] = true
+
+		if u, err := url.Parse(source.Addr); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			problemf("invalid address %q for remote source %v", source.Addr, source.Name)
+		}
+	}
+
+	if s.SourceCheckInterval < 1 {
+		problemf("the source check interval must be at least 1 second")
+	}
+
+	if (len(s.RemoteCertFile) > 0) != (len(s.RemoteKeyFile) > 0) {
+		problemf("the remote client certificate and key must be given together")
+	}
+
+	if (len(s.ReplicatorCertFile) > 0) != (len(s.ReplicatorKeyFile) > 0) {
+		problemf("the replicator client certificate and key must be given together")
+	}
+
+	for _, pin := range s.RemotePins {
+		if _, err := couchclient.ParsePin(pin); err != nil {
+			problemf("%v", err)
+		}
+	}
+
+	if s.ReplicationAuth != "auto" && s.ReplicationAuth != "headers" && s.ReplicationAuth != "auth" {
+		problemf("invalid replication auth %q", s.ReplicationAuth)
+	}
+
+	if s.ShutdownPolicy != "leave" && s.ShutdownPolicy != "delete" {
+		problemf("invalid shutdown policy %q", s.ShutdownPolicy)
+	}
+
+	if s.MinInterval < 1 {
+		problemf("the minimum interval must be at least 1 second")
+	}
+
+	if s.DefaultInterval < s.MinInterval {
+		problemf("the default interval of %v is less than the minimum interval of %v", s.DefaultInterval, s.MinInterval)
+	}
+
+	if s.WaitLimit < 1 {
+		problemf("the wait limit must be at least 1 second")
+	}
+
+	if s.ProgressInterval < 0 {
+		problemf("the progress interval can't be negative")
+	}
+
+	if s.VerifyInterval < 0 {
+		problemf("the verify interval can't be negative")
+	}
+
+	if s.VerifySampleSize < 0 {
+		problemf("the verify sample size can't be negative")
+	}
+
+	for _, addr := range s.EventURLs {
+		if u, err := url.Parse(addr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problemf("invalid event url %q", addr)
+		}
+	}
+
+	for _, origin := range s.EventOrigins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			problemf("invalid event origin %q", origin)
+		}
+	}
+
+	if s.EventPollInterval < 1 {
+		problemf("the event poll interval must be at least 1 second")
+	}
+
+	if s.HistoryLimit < 0 {
+		problemf("the history limit can't be negative")
+	}
+
+	if len(problems) > 0 {
+		return nerr.Create("Invalid settings: "+strings.Join(problems, "; "), "invalid_args")
+	}
+
+	return nil
+}
+
+//Source is a remote couch server to replicate from
+type Source struct {
+	Name string
+	Addr string
+}
+
+//Sources are the remote servers to replicate from, in order of preference. Sources without a name are named after
+//their position.
+func (s Settings) Sources() []Source {
+	if len(s.RemoteSources) == 0 {
+		if len(s.RemoteAddr) == 0 {
+			return nil
+		}
+
+		return []Source{{Name: "primary", Addr: s.RemoteAddr}}
+	}
+
+	toReturn := make([]Source, 0, len(s.RemoteSources))
+	for i, source := range s.RemoteSources {
+		src := parseSource(source)
+		if len(src.Name) == 0 {
+			src.Name = fmt.Sprintf("source%d", i+1)
+		}
+
+		toReturn = append(toReturn, src)
+	}
+
+	return toReturn
+}
+
+//Aliases are remote servers that are only replicated from by databases that name them
+func (s Settings) Aliases() []Source {
+	toReturn := make([]Source, 0, len(s.RemoteAliases))
+	for _, alias := range s.RemoteAliases {
+		toReturn = append(toReturn, parseSource(alias))
+	}
+
+	return toReturn
+}
+
+//parseSource parses name=url, or just url
+func parseSource(s string) Source {
+	//the scheme of a url has a colon, but never an equals sign
+	if eq := strings.Index(s, "="); eq > 0 && !strings.Contains(s[:eq], ":") {
+		return Source{Name: s[:eq], Addr: s[eq+1:]}
+	}
+
+	return Source{Addr: s}
+}
+
+//MinIntervalDuration is MinInterval as a duration
+func (s Settings) MinIntervalDuration() time.Duration {
+	return time.Duration(s.MinInterval) * time.Second
+}
@@ -0,0 +1,74 @@
+package settings
+
+import (
+	"os"
+	"testing"
+)
+
+//required are the flags every test needs to get past validation
+var required = []string{
+	"-hostname", "ITB-1101-CP1",
+	"-couch-addr", "http://localhost:5984",
+	"-remote-addr", "https://couch:6984",
+	"-remote-user", "user",
+	"-remote-pass", "pass",
+}
+
+func TestBoolFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{"unset", nil, false},
+		{"bare", []string{"-stop-replication"}, true},
+		{"true", []string{"-stop-replication=true"}, true},
+		{"false", []string{"-stop-replication=false"}, false},
+		{"before other flags", []string{"-stop-replication", "-port", "8080"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Load(append(append([]string{}, required...), tt.args...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if s.StopReplication != tt.want {
+				t.Errorf("got stop replication %v, want %v", s.StopReplication, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagsOverrideEnv(t *testing.T) {
+	if err := os.Setenv("MIN_INTERVAL", "20"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Unsetenv("MIN_INTERVAL")
+
+	s, err := Load(required)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.MinInterval != 20 {
+		t.Errorf("got min interval %v, want 20 from the environment", s.MinInterval)
+	}
+
+	s, err = Load(append(append([]string{}, required...), "-min-interval", "30", "-port", "8080"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.MinInterval != 30 {
+		t.Errorf("got min interval %v, want 30 from the flag", s.MinInterval)
+	}
+	if s.Port != ":8080" {
+		t.Errorf("got port %q, want :8080", s.Port)
+	}
+}
+
+func TestInvalidFlags(t *testing.T) {
+	if _, err := Load(append(append([]string{}, required...), "-min-interval", "often")); err == nil || err.Type != "invalid_args" {
+		t.Errorf("got %v, want an invalid_args error for a number that isn't one", err)
+	}
+}